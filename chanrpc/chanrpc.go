@@ -7,10 +7,26 @@ import (
 	"squash/conf"
 )
 
+//调用优先级
+const (
+	PriorityNormal = iota //普通优先级（默认）
+	PriorityHigh          //高优先级，如踢人等管理调用（与同一对象的普通调用之间不保证顺序）
+)
+
 //rpc服务器
+//调用按优先级进入不同的管道，处理调用的goroutine应优先读取ChanCallHigh（见module.Skeleton）
 type Server struct {
-	functions map[interface{}]interface{} //id->func映射
-	ChanCall  chan *CallInfo              //调用信息管道，用于传递调用信息
+	functions    map[interface{}]interface{} //id->func映射
+	ChanCall     chan *CallInfo              //调用信息管道，用于传递普通优先级的调用信息
+	ChanCallHigh chan *CallInfo              //高优先级调用信息管道
+}
+
+//rpc服务器统计（各优先级管道的长度和容量）
+type Stats struct {
+	NormalLen int //普通优先级管道当前长度
+	NormalCap int //普通优先级管道容量
+	HighLen   int //高优先级管道当前长度
+	HighCap   int //高优先级管道容量
 }
 
 //调用信息
@@ -31,6 +47,7 @@ type RetInfo struct {
 //rpc客户端
 type Client struct {
 	s               *Server       //rpc服务器引用
+	priority        int           //调用优先级
 	chanSyncRet     chan *RetInfo //同步调用返回信息管道
 	ChanAsynRet     chan *RetInfo //异步调用返回信息管道
 	pendingAsynCall int           //待处理的异步调用
@@ -44,6 +61,8 @@ func NewServer(l int) *Server {
 	s.functions = make(map[interface{}]interface{})
 	//创建调用信息管道
 	s.ChanCall = make(chan *CallInfo, l)
+	//创建高优先级调用信息管道
+	s.ChanCallHigh = make(chan *CallInfo, l)
 	return s
 }
 
@@ -130,6 +149,11 @@ func (s *Server) Exec(ci *CallInfo) (err error) {
 
 //rpc服务器调用自己
 func (s *Server) Go(id interface{}, args ...interface{}) {
+	s.GoPriority(PriorityNormal, id, args...)
+}
+
+//rpc服务器以指定优先级调用自己
+func (s *Server) GoPriority(priority int, id interface{}, args ...interface{}) {
 	//根据id获取所映射的func
 	f := s.functions[id]
 	//func未注册
@@ -143,15 +167,38 @@ func (s *Server) Go(id interface{}, args ...interface{}) {
 	}()
 
 	//将调用信息通过管道传输到rpc服务器
-	s.ChanCall <- &CallInfo{f: f, args: args}
+	s.chanCall(priority) <- &CallInfo{f: f, args: args}
+}
+
+//获取优先级对应的调用信息管道
+func (s *Server) chanCall(priority int) chan *CallInfo {
+	if priority == PriorityHigh {
+		return s.ChanCallHigh
+	}
+
+	return s.ChanCall
+}
+
+//获取rpc服务器统计
+func (s *Server) Stats() Stats {
+	return Stats{
+		NormalLen: len(s.ChanCall),
+		NormalCap: cap(s.ChanCall),
+		HighLen:   len(s.ChanCallHigh),
+		HighCap:   cap(s.ChanCallHigh),
+	}
 }
 
 //关闭rpc服务器
 func (s *Server) Close() {
 	//关闭调用信息管道
+	close(s.ChanCallHigh)
 	close(s.ChanCall)
 
 	//遍历所有未处理完的调用信息，将"管道已关闭"错误发送到调用信息的返回值管道中
+	for ci := range s.ChanCallHigh {
+		s.ret(ci, &RetInfo{err: errors.New("chanrpc server closed")})
+	}
 	for ci := range s.ChanCall {
 		s.ret(ci, &RetInfo{err: errors.New("chanrpc server closed")})
 	}
@@ -170,6 +217,11 @@ func (s *Server) Open(l int) *Client {
 	return c
 }
 
+//设置客户端发起调用的优先级（PriorityNormal或PriorityHigh）
+func (c *Client) SetPriority(priority int) {
+	c.priority = priority
+}
+
 //根据id获取所映射的func
 func (c *Client) f(id interface{}, n int) (f interface{}, err error) {
 	//根据id获取所映射的func
@@ -211,11 +263,14 @@ func (c *Client) call(ci *CallInfo, block bool) (err error) {
 		}
 	}()
 
+	//根据优先级选择管道
+	chanCall := c.s.chanCall(c.priority)
+
 	if block { //阻塞，将调用消息通过管道传输到rpc服务器
-		c.s.ChanCall <- ci
+		chanCall <- ci
 	} else { //不阻塞，当管道满时，返回"管道已满"错误（利用default特性检测chan是否已满）
 		select {
-		case c.s.ChanCall <- ci:
+		case chanCall <- ci:
 		default:
 			err = errors.New("chanrpc channel full")
 		}
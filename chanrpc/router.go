@@ -0,0 +1,106 @@
+package chanrpc
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+)
+
+//路由器接口，处理器通过路由器将消息发送到rpc服务器处理
+//*Server和*ShardRouter都实现了该接口
+type Router interface {
+	Go(id interface{}, args ...interface{}) //发起调用
+}
+
+//路由器是否为空，包括值为nil的*Server、*ShardRouter等（接口本身不为nil，调用时会panic）
+func IsNilRouter(r Router) bool {
+	if r == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(r)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return v.IsNil()
+	}
+
+	return false
+}
+
+//分片路由器，按键将调用分发到多个rpc服务器，相同键的调用总是由同一个rpc服务器按顺序处理
+type ShardRouter struct {
+	servers []*Server                            //rpc服务器
+	key     func(args []interface{}) interface{} //从调用参数中获取键
+}
+
+//创建分片路由器
+//key从调用参数中获取键，处理器路由时参数为[msg, userData, seq]，例如按用户数据中的房间ID分片
+//key为空或者返回nil时，调用分发到第一个rpc服务器
+func NewShardRouter(servers []*Server, key func(args []interface{}) interface{}) *ShardRouter {
+	//至少需要一个rpc服务器
+	if len(servers) == 0 {
+		panic("chanrpc: shard router needs at least one server")
+	}
+
+	r := new(ShardRouter)
+	r.servers = servers
+	r.key = key
+
+	return r
+}
+
+//在所有rpc服务器上注册id->func映射，必须在Go之前调用
+func (r *ShardRouter) Register(id interface{}, f interface{}) {
+	for _, s := range r.servers {
+		s.Register(id, f)
+	}
+}
+
+//按键选择rpc服务器并发起调用
+func (r *ShardRouter) Go(id interface{}, args ...interface{}) {
+	var key interface{}
+	if r.key != nil {
+		key = r.key(args)
+	}
+
+	r.Server(key).Go(id, args...)
+}
+
+//获取键对应的rpc服务器
+func (r *ShardRouter) Server(key interface{}) *Server {
+	return r.servers[r.shard(key)]
+}
+
+//获取所有rpc服务器
+func (r *ShardRouter) Servers() []*Server {
+	return r.servers
+}
+
+//计算键对应的rpc服务器下标
+func (r *ShardRouter) shard(key interface{}) int {
+	n := uint64(len(r.servers))
+
+	//整数键直接取模，其他键使用fnv哈希
+	switch k := key.(type) {
+	case nil:
+		return 0
+	case int:
+		return int(uint64(k) % n)
+	case int32:
+		return int(uint64(k) % n)
+	case int64:
+		return int(uint64(k) % n)
+	case uint32:
+		return int(uint64(k) % n)
+	case uint64:
+		return int(k % n)
+	case string:
+		h := fnv.New64a()
+		h.Write([]byte(k))
+		return int(h.Sum64() % n)
+	}
+
+	h := fnv.New64a()
+	fmt.Fprint(h, key)
+	return int(h.Sum64() % n)
+}
@@ -0,0 +1,110 @@
+//导出protobuf消息描述和客户端代码
+//消息从protoc生成的描述符集合中读取，按-messages的顺序注册（与服务器的注册顺序一致，消息ID相同）
+//生成描述符集合：protoc --include_imports --descriptor_set_out=msg.pb msg.proto
+//例如：schema -descriptor msg.pb -messages msg.Login,msg.LoginResult -c2s msg.Login -lenmsglen 2 -out client
+//json处理器的消息由go类型定义，需要在服务器中调用schema.FromJSON和schema.Export导出
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"squash/network/protobuf"
+	"squash/network/schema"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func main() {
+	descriptor := flag.String("descriptor", "", "protoc生成的描述符集合文件（--descriptor_set_out，需要--include_imports）")
+	messages := flag.String("messages", "", "按注册顺序排列的消息全名，以逗号分隔")
+	c2s := flag.String("c2s", "", "客户端发往服务器的消息全名，以逗号分隔，其他消息为服务器发往客户端")
+	out := flag.String("out", ".", "输出目录")
+	lenMsgLen := flag.Int("lenmsglen", 2, "消息长度占用字节数（1、2、4，WebSocket为0）")
+	littleEndian := flag.Bool("littleendian", false, "长度、id和序号是否小端")
+	seq := flag.Bool("seq", false, "是否启用序号")
+	frag := flag.Bool("frag", false, "是否启用分片（gate设置了MaxTotalMsgLen）")
+	maxMsgLen := flag.Uint("maxmsglen", 4096, "单条消息的最大长度（gate的MaxMsgLen）")
+	flag.Parse()
+
+	if *descriptor == "" || *messages == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*descriptor, *messages, *c2s, *out, schema.Framing{
+		LenMsgLen:    *lenMsgLen,
+		LittleEndian: *littleEndian,
+		Seq:          *seq,
+		Frag:         *frag,
+		MaxMsgLen:    uint32(*maxMsgLen),
+	}); err != nil {
+		fmt.Fprintln(os.Stderr, "schema:", err)
+		os.Exit(1)
+	}
+}
+
+//读取描述符集合，注册消息并导出
+func run(descriptor string, messages string, c2s string, out string, framing schema.Framing) error {
+	//读取描述符集合
+	data, err := os.ReadFile(descriptor)
+	if err != nil {
+		return err
+	}
+	fds := new(descriptorpb.FileDescriptorSet)
+	if err := proto.Unmarshal(data, fds); err != nil {
+		return fmt.Errorf("%v: %v", descriptor, err)
+	}
+
+	p := protobuf.NewProcessor()
+	p.SetByteOrder(framing.LittleEndian)
+	p.SetSeq(framing.Seq)
+	if err := p.SetDescriptorSet(fds); err != nil {
+		return err
+	}
+
+	//按顺序注册消息
+	for _, name := range split(messages) {
+		p.RegisterByName(protoreflect.FullName(name))
+	}
+
+	//客户端发往服务器的消息设置空处理函数，导出时方向为c2s
+	handled := make(map[protoreflect.FullName]bool)
+	for _, name := range split(c2s) {
+		handled[protoreflect.FullName(name)] = true
+	}
+	p.RangeDescriptors(func(id uint16, md protoreflect.MessageDescriptor) {
+		if handled[md.FullName()] {
+			delete(handled, md.FullName())
+			p.SetHandler(dynamicpb.NewMessage(md), func([]interface{}) {})
+		}
+	})
+	//没有注册的消息按名字排序后全部报告
+	if len(handled) > 0 {
+		unknown := make([]string, 0, len(handled))
+		for name := range handled {
+			unknown = append(unknown, string(name))
+		}
+		sort.Strings(unknown)
+		return fmt.Errorf("c2s messages not in -messages: %v", strings.Join(unknown, ", "))
+	}
+
+	return schema.Export(schema.FromProtobuf(p, framing), out)
+}
+
+//按逗号拆分，忽略空白
+func split(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
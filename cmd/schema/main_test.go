@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"squash/network/schema"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+//写入包含test.A和test.B两个空消息的描述符集合
+func writeDescriptor(t *testing.T) string {
+	fds := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("A")},
+			{Name: proto.String("B")},
+		},
+	}}}
+	data, err := proto.Marshal(fds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "msg.pb")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	descriptor := writeDescriptor(t)
+	out := t.TempDir()
+	if err := run(descriptor, "test.A, test.B", "test.A", out, schema.Framing{LenMsgLen: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"schema.json", "messages.ts", "Messages.cs"} {
+		if _, err := os.Stat(filepath.Join(out, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestRunUnknownC2S(t *testing.T) {
+	descriptor := writeDescriptor(t)
+
+	//所有未注册的消息按名字排序报告
+	err := run(descriptor, "test.A", "test.Z,test.B,test.A,test.C", t.TempDir(), schema.Framing{LenMsgLen: 2})
+	want := "c2s messages not in -messages: test.B, test.C, test.Z"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %v", err, want)
+	}
+}
@@ -16,6 +16,9 @@ var (
 	//profile路径
 	ProfilePath string
 
+	//任务存储文件路径（模块未指定任务存储时使用），默认不持久化
+	JobStorePath string
+
 	//集群监听地址
 	ListenAddr string
 	//连接地址集合
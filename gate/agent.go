@@ -1,8 +1,21 @@
 package gate
 
+import (
+	"net/http"
+	"squash/network"
+)
+
 type Agent interface {
 	WriteMsg(msg interface{})     //发送消息
 	Close()                       //关闭代理
 	UserData() interface{}        //获取用户数据
 	SetUserData(data interface{}) //设置用户数据
+	Authenticated() bool          //是否已认证
+	SetAuthenticated(auth bool)   //设置是否已认证（如在登录消息的处理函数中设置），同时恢复屏障消息之后的读取
+	ProtocolVersion() uint32      //获取客户端协议版本
+	SetProtocolVersion(v uint32)  //设置客户端协议版本（如在握手消息的处理函数中设置），之后的消息按该版本解码，同时恢复屏障消息之后的读取
+	Resume()                      //恢复屏障消息之后的读取（屏障消息的处理函数没有设置认证状态和协议版本时调用）
+	Request() *http.Request       //获取ws升级请求，可以读取请求头和查询参数（tcp连接返回nil）
+	Subprotocol() string          //获取ws协商的子协议（tcp连接返回空字符串）
+	Stats() network.ConnStats     //获取连接统计（连接不支持统计时返回零值）
 }
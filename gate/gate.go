@@ -1,10 +1,14 @@
 package gate
 
 import (
+	"net/http"
 	"reflect"
 	"squash/chanrpc"
 	"squash/log"
+	"squash/module"
 	"squash/network"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,12 +17,32 @@ type Gate struct {
 	MaxConnNum      int               //最大连接数
 	PendingWriteNum int               //发送缓冲区长度
 	MaxMsgLen       uint32            //最大消息长度
+	MaxTotalMsgLen  uint32            //分片重组后的最大消息长度，为0时不启用分片（客户端也需要启用分片）
 	Processor       network.Processor //消息解析器
 	AgentChanRPC    *chanrpc.Server   //rpc服务器
 
+	//中间件，作用于所有代理的读取和发送，在处理器的中间件之外执行
+	RouteMiddlewares   []network.RouteMiddleware   //路由中间件，userData为代理
+	MarshalMiddlewares []network.MarshalMiddleware //编码中间件，userData为代理
+
+	//认证
+	DropUnauthenticated bool                    //未认证的代理发送需要认证的消息时，丢弃消息而不是断开连接
+	BarrierTimeout      time.Duration           //路由屏障消息后等待处理结果的时限，超时关闭连接，默认10秒
+	middlewareChain     network.MiddlewareChain //中间件链
+
+	//运行中的服务器，用于读取统计
+	wsServer  atomic.Pointer[network.WSServer]  //ws服务器
+	tcpServer atomic.Pointer[network.TCPServer] //tcp服务器
+
 	//websocket
-	WSAddr      string        //ws地址
-	HTTPTimeout time.Duration //超时时限
+	WSAddr       string                             //ws地址
+	HTTPTimeout  time.Duration                      //超时时限
+	CheckOrigin  func(r *http.Request) bool         //检查请求来源，为空时允许所有来源
+	Authorize    func(r *http.Request) (int, error) //升级前的鉴权函数
+	Subprotocols []string                           //支持的子协议
+	WSPath       string                             //ws连接的路径，为空时为"/"
+	FrameMode    int                                //帧模式
+	HTTPHandler  http.Handler                       //处理其他路径的http处理器（与ws共用监听端口）
 
 	//tcp
 	TCPAddr      string //tcp地址
@@ -28,13 +52,28 @@ type Gate struct {
 
 //代理
 type agent struct {
-	conn     network.Conn //连接
-	gate     *Gate        //网关
-	userData interface{}  //用户数据
+	conn          network.Conn    //连接（启用分片时为分片连接）
+	wsConn        *network.WSConn //ws连接（tcp连接为空）
+	gate          *Gate           //网关
+	userData      interface{}     //用户数据
+	authenticated int32           //是否已认证（原子操作）
+	version       uint32          //客户端协议版本（原子操作），为0时使用最新版本
+	resume        chan struct{}   //恢复读取信号（屏障消息）
+	closeChan     chan struct{}   //关闭信号，关闭时停止等待屏障消息的处理结果
+	closeOnce     sync.Once       //保证只关闭一次
 }
 
 //实现module.Module接口的Run方法
 func (gate *Gate) Run(closeSig chan bool) {
+	//创建中间件链
+	gate.middlewareChain.UseRoute(gate.RouteMiddlewares...)
+	gate.middlewareChain.UseMarshal(gate.MarshalMiddlewares...)
+
+	//屏障消息的等待时限
+	if gate.BarrierTimeout <= 0 {
+		gate.BarrierTimeout = 10 * time.Second
+	}
+
 	//创建ws服务器
 	var wsServer *network.WSServer
 	//设置ws服务器相关参数
@@ -44,9 +83,16 @@ func (gate *Gate) Run(closeSig chan bool) {
 		wsServer.MaxConnNum = gate.MaxConnNum                          //最大连接数
 		wsServer.PendingWriteNum = gate.PendingWriteNum                //发送缓冲区长度
 		wsServer.MaxMsgLen = gate.MaxMsgLen                            //最大消息长度
+		wsServer.FrameMode = gate.FrameMode                            //帧模式
 		wsServer.HTTPTimeout = gate.HTTPTimeout                        //http连接超时时限
+		wsServer.CheckOrigin = gate.CheckOrigin                        //来源检查函数
+		wsServer.Authorize = gate.Authorize                            //鉴权函数
+		wsServer.Subprotocols = gate.Subprotocols                      //子协议
+		wsServer.Path = gate.WSPath                                    //ws连接的路径
+		wsServer.HTTPHandler = gate.HTTPHandler                        //其他路径的http处理器
+		wsServer.Ready = module.Ready                                  //就绪检查函数
 		wsServer.NewAgent = func(conn *network.WSConn) network.Agent { //创建代理函数
-			a := &agent{conn: conn, gate: gate}
+			a := gate.newAgent(gate.wrapConn(conn, conn.MaxMsgLen()), conn)
 			//代理rpc服务器，用于接受NewAgent和CloseAgentRPC调用
 			if gate.AgentChanRPC != nil {
 				gate.AgentChanRPC.Go("NewAgent", a)
@@ -68,7 +114,7 @@ func (gate *Gate) Run(closeSig chan bool) {
 		tcpServer.MaxMsgLen = gate.MaxMsgLen                             //最大消息长度
 		tcpServer.LittleEndian = gate.LittleEndian                       //大小端
 		tcpServer.NewAgent = func(conn *network.TCPConn) network.Agent { //创建代理函数
-			a := &agent{conn: conn, gate: gate}
+			a := gate.newAgent(gate.wrapConn(conn, conn.MaxMsgLen()), nil)
 			//代理rpc服务器，用于接受NewAgent和CloseAgentRPC调用
 			if gate.AgentChanRPC != nil {
 				gate.AgentChanRPC.Go("NewAgent", a)
@@ -81,11 +127,13 @@ func (gate *Gate) Run(closeSig chan bool) {
 	//启动ws服务器
 	if wsServer != nil {
 		wsServer.Start()
+		gate.wsServer.Store(wsServer)
 	}
 
 	//启动tcp服务器
 	if tcpServer != nil {
 		tcpServer.Start()
+		gate.tcpServer.Store(tcpServer)
 	}
 
 	//等待关闭信号
@@ -93,15 +141,60 @@ func (gate *Gate) Run(closeSig chan bool) {
 
 	//关闭ws服务器
 	if wsServer != nil {
+		gate.wsServer.Store(nil)
 		wsServer.Close()
 	}
 
 	//关闭tcp服务器
 	if tcpServer != nil {
+		gate.tcpServer.Store(nil)
 		tcpServer.Close()
 	}
 }
 
+//获取ws服务器统计，未运行ws服务器时返回零值（可以在其他goroutine中调用）
+func (gate *Gate) WSStats() network.ServerStats {
+	if s := gate.wsServer.Load(); s != nil {
+		return s.Stats()
+	}
+
+	return network.ServerStats{}
+}
+
+//获取tcp服务器统计，未运行tcp服务器时返回零值（可以在其他goroutine中调用）
+func (gate *Gate) TCPStats() network.ServerStats {
+	if s := gate.tcpServer.Load(); s != nil {
+		return s.Stats()
+	}
+
+	return network.ServerStats{}
+}
+
+//创建代理，wsConn为ws连接（tcp连接为空）
+func (gate *Gate) newAgent(conn network.Conn, wsConn *network.WSConn) *agent {
+	a := new(agent)
+	a.conn = conn
+	a.wsConn = wsConn
+	a.gate = gate
+	a.resume = make(chan struct{}, 1)
+	a.closeChan = make(chan struct{})
+	return a
+}
+
+//设置了分片重组后的最大消息长度时，在连接之上启用分片
+func (gate *Gate) wrapConn(conn network.Conn, maxMsgLen uint32) network.Conn {
+	if gate.MaxTotalMsgLen == 0 {
+		return conn
+	}
+
+	return network.NewFragConn(conn, maxMsgLen, gate.MaxTotalMsgLen)
+}
+
+//编码消息（编码中间件链的最后一环）
+func (gate *Gate) marshal(msg interface{}, _ interface{}) ([][]byte, error) {
+	return gate.Processor.Marshal(msg)
+}
+
 //实现module.Module接口的OnDestroy方法
 func (gate *Gate) OnDestroy() {}
 
@@ -118,28 +211,79 @@ func (a *agent) Run() {
 
 		//消息处理器不为空，解码消息
 		if a.gate.Processor != nil {
-			//解码
-			msg, err := a.gate.Processor.Unmarshal(data)
+			//解码，处理器支持版本时按客户端协议版本解码
+			var msg interface{}
+			if vp, ok := a.gate.Processor.(network.VersionedProcessor); ok {
+				msg, err = vp.UnmarshalVersion(data, a.ProtocolVersion())
+			} else {
+				msg, err = a.gate.Processor.Unmarshal(data)
+			}
 			//解码失败
 			if err != nil {
 				log.Debug("unmarshal message error: %v", err)
 				break
 			}
 
-			//路由，分发数据
-			err = a.gate.Processor.Route(msg, a)
+			//屏障消息，丢弃之前残留的恢复信号
+			barrier := false
+			if bp, ok := a.gate.Processor.(network.BarrierProcessor); ok && bp.IsBarrier(msg) {
+				barrier = true
+				select {
+				case <-a.resume:
+				default:
+				}
+			}
+
+			//路由，消息经过中间件后分发数据
+			err = a.gate.middlewareChain.ApplyRoute(msg, a, a.gate.Processor.Route)
 			//路由失败
 			if err != nil {
+				//未认证并且设置了丢弃，只丢弃该消息
+				if err == network.ErrNotAuthenticated && a.gate.DropUnauthenticated {
+					log.Debug("drop message %v: %v", reflect.TypeOf(msg), err)
+					continue
+				}
+
 				log.Debug("route message error: %v", err)
 				break
 			}
+
+			//屏障消息，等待处理结果之后再读取下一条消息
+			if barrier && !a.wait() {
+				break
+			}
 		}
 	}
 }
 
+//等待屏障消息的处理结果，超时或代理关闭时返回false
+func (a *agent) wait() bool {
+	t := time.NewTimer(a.gate.BarrierTimeout)
+	defer t.Stop()
+
+	select {
+	case <-a.resume:
+		return true
+	case <-a.closeChan:
+		return false
+	case <-t.C:
+		log.Debug("barrier message timeout")
+		return false
+	}
+}
+
+//恢复屏障消息之后的读取
+func (a *agent) signal() {
+	select {
+	case a.resume <- struct{}{}:
+	default:
+	}
+}
+
 //实现network.Agent接口的OnClose方法
 func (a *agent) OnClose() {
 	//rpc服务器不为空，打开一个rpc客户端，同步调用CloseAgent方法
+	//与NewAgent和路由的消息使用同一个（普通优先级）队列，保证CloseAgent在该代理之前的所有调用之后执行
 	if a.gate.AgentChanRPC != nil {
 		err := a.gate.AgentChanRPC.Open(0).Call0("CloseAgent", a)
 		if err != nil {
@@ -152,14 +296,27 @@ func (a *agent) OnClose() {
 func (a *agent) WriteMsg(msg interface{}) {
 	//消息处理器不为空，编码消息
 	if a.gate.Processor != nil {
-		//编码
-		data, err := a.gate.Processor.Marshal(msg)
+		//编码，消息经过中间件后再编码
+		data, err := a.gate.middlewareChain.ApplyMarshal(msg, a, a.gate.marshal)
 		//编码失败
 		if err != nil {
 			log.Error("marshal message %v error: %v", reflect.TypeOf(msg), err)
 			return
 		}
 
+		//消息被中间件丢弃
+		if data == nil {
+			return
+		}
+
+		//处理器可以选择帧类型并且连接支持指定帧类型，使用处理器选择的帧类型发送消息
+		if ft, ok := a.gate.Processor.(network.FrameTyper); ok {
+			if fw, ok := a.conn.(network.FrameWriter); ok {
+				fw.WriteFrame(ft.FrameType(msg), data...)
+				return
+			}
+		}
+
 		//发送消息
 		a.conn.WriteMsg(data...)
 	}
@@ -167,9 +324,17 @@ func (a *agent) WriteMsg(msg interface{}) {
 
 //实现gate.Agent接口的Close方法
 func (a *agent) Close() {
+	a.closeOnce.Do(func() {
+		close(a.closeChan)
+	})
 	a.conn.Close()
 }
 
+//实现gate.Agent接口的Resume方法
+func (a *agent) Resume() {
+	a.signal()
+}
+
 //实现gate.Agent接口的UserData方法
 func (a *agent) UserData() interface{} {
 	return a.userData
@@ -179,3 +344,61 @@ func (a *agent) UserData() interface{} {
 func (a *agent) SetUserData(data interface{}) {
 	a.userData = data
 }
+
+//实现gate.Agent接口的Authenticated方法
+func (a *agent) Authenticated() bool {
+	return atomic.LoadInt32(&a.authenticated) == 1
+}
+
+//实现gate.Agent接口的SetAuthenticated方法
+func (a *agent) SetAuthenticated(auth bool) {
+	if auth {
+		atomic.StoreInt32(&a.authenticated, 1)
+	} else {
+		atomic.StoreInt32(&a.authenticated, 0)
+	}
+
+	//恢复屏障消息之后的读取
+	a.signal()
+}
+
+//实现gate.Agent接口的Request方法
+func (a *agent) Request() *http.Request {
+	if a.wsConn == nil {
+		return nil
+	}
+
+	return a.wsConn.Request()
+}
+
+//实现gate.Agent接口的Subprotocol方法
+func (a *agent) Subprotocol() string {
+	if a.wsConn == nil {
+		return ""
+	}
+
+	return a.wsConn.Subprotocol()
+}
+
+//实现gate.Agent接口的Stats方法
+func (a *agent) Stats() network.ConnStats {
+	//连接不支持统计时返回零值
+	if sc, ok := a.conn.(network.StatsConn); ok {
+		return sc.Stats()
+	}
+
+	return network.ConnStats{}
+}
+
+//实现gate.Agent接口的ProtocolVersion方法
+func (a *agent) ProtocolVersion() uint32 {
+	return atomic.LoadUint32(&a.version)
+}
+
+//实现gate.Agent接口的SetProtocolVersion方法
+func (a *agent) SetProtocolVersion(v uint32) {
+	atomic.StoreUint32(&a.version, v)
+
+	//恢复屏障消息之后的读取
+	a.signal()
+}
@@ -0,0 +1,79 @@
+package g
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"squash/conf"
+	"squash/log"
+)
+
+//异步函数异常时传递给回调的错误
+type PanicError struct {
+	Value interface{} //异常值
+	Stack []byte      //调用栈踪迹（conf.LenStackBuf为0时为空）
+}
+
+//实现error接口
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+//捕获异常，转换为PanicError并输出日志（在defer中调用）
+func newPanicError(r interface{}) *PanicError {
+	e := &PanicError{Value: r}
+	if conf.LenStackBuf > 0 { //配置了调用栈踪迹缓冲长度，将当前goroutine的调用栈踪迹格式化后写入到buf中
+		buf := make([]byte, conf.LenStackBuf)
+		l := runtime.Stack(buf, false)
+		e.Stack = buf[:l]
+		log.Error("%v: %s", r, e.Stack)
+	} else {
+		log.Error("%v", r)
+	}
+
+	return e
+}
+
+//可取消的Go，在其他goroutine中执行f(ctx)，完成后在原goroutine中以f的错误调用cb
+//f异常时错误为*PanicError；ctx在Go关闭（模块关闭）或调用返回的取消函数时取消，f应检查ctx及时返回
+//使用工作池且任务被拒绝时，直接以ErrPoolFull调用cb
+func (g *Go) GoCtx(f func(ctx context.Context) error, cb func(err error)) context.CancelFunc {
+	ctx, cancel := context.WithCancel(g.ctx)
+
+	var err error
+	work := func() {
+		//释放ctx
+		defer cancel()
+		//捕获异常，作为错误
+		defer func() {
+			if r := recover(); r != nil {
+				err = newPanicError(r)
+			}
+		}()
+
+		err = f(ctx)
+	}
+	_cb := func() {
+		if cb != nil {
+			cb(err)
+		}
+	}
+
+	if g.pool != nil {
+		if err := g.submit(work, _cb, g.pool.block); err != nil {
+			cancel()
+			if cb != nil {
+				cb(err)
+			}
+		}
+	} else {
+		g.Go(work, _cb)
+	}
+
+	return cancel
+}
+
+//获取根上下文，Go关闭时取消
+func (g *Go) Context() context.Context {
+	return g.ctx
+}
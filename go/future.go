@@ -0,0 +1,257 @@
+package g
+
+import (
+	"errors"
+	"squash/chanrpc"
+	"time"
+)
+
+//Future超时
+var ErrTimeout = errors.New("future timeout")
+
+//Future，表示一个异步操作的结果
+//Future的方法和回调（Then、Done的函数）都在所属Go的回调goroutine（模块的goroutine）中调用
+//异步操作在其他goroutine中完成后，结果通过回调管道发送回模块的goroutine
+type Future struct {
+	g         *Go         //所属Go
+	done      bool        //是否已完成
+	val       interface{} //结果
+	err       error       //错误
+	callbacks []func()    //完成时调用的函数
+}
+
+//创建未完成的Future，由Resolve完成（用于将其他回调形式的异步操作转换为Future）
+func (g *Go) NewFuture() *Future {
+	return &Future{g: g}
+}
+
+//在其他goroutine中执行f，返回f结果的Future，f异常时Future的错误为*PanicError
+//使用工作池且任务被拒绝时，Future的错误为ErrPoolFull
+func (g *Go) Async(f func() (interface{}, error)) *Future {
+	fut := g.NewFuture()
+
+	var val interface{}
+	var err error
+	work := func() {
+		//捕获异常，作为错误
+		defer func() {
+			if r := recover(); r != nil {
+				err = newPanicError(r)
+			}
+		}()
+
+		val, err = f()
+	}
+	cb := func() {
+		fut.Resolve(val, err)
+	}
+
+	if g.pool != nil {
+		if err := g.submit(work, cb, g.pool.block); err != nil {
+			fut.Resolve(nil, err)
+		}
+	} else {
+		g.Go(work, cb)
+	}
+
+	return fut
+}
+
+//发起rpc异步调用，返回调用结果的Future（rpc函数有一个返回值）
+//回调在执行c.Cb的goroutine中完成Future，c.ChanAsynRet应由模块的goroutine读取
+func (g *Go) AsynCall(c *chanrpc.Client, id interface{}, args ...interface{}) *Future {
+	fut := g.NewFuture()
+	c.AsynCall(id, append(args, func(ret interface{}, err error) {
+		fut.Resolve(ret, err)
+	})...)
+
+	return fut
+}
+
+//完成Future，依次调用已注册的函数，已完成时忽略
+func (fut *Future) Resolve(val interface{}, err error) {
+	if fut.done {
+		return
+	}
+
+	fut.done = true
+	fut.val = val
+	fut.err = err
+
+	callbacks := fut.callbacks
+	fut.callbacks = nil
+	for _, cb := range callbacks {
+		cb()
+	}
+}
+
+//是否已完成
+func (fut *Future) IsDone() bool {
+	return fut.done
+}
+
+//获取结果，未完成时返回nil, nil
+func (fut *Future) Result() (interface{}, error) {
+	return fut.val, fut.err
+}
+
+//完成时调用fn，已完成时立即调用，fn异常时输出日志
+func (fut *Future) Done(fn func(val interface{}, err error)) {
+	fut.onDone(func() {
+		//捕获异常，避免Resolve中之后注册的函数不被调用
+		defer func() {
+			if r := recover(); r != nil {
+				newPanicError(r)
+			}
+		}()
+
+		fn(fut.val, fut.err)
+	})
+}
+
+//注册完成时调用的函数
+func (fut *Future) onDone(cb func()) {
+	if fut.done {
+		cb()
+		return
+	}
+
+	fut.callbacks = append(fut.callbacks, cb)
+}
+
+//成功完成时以结果调用fn，返回fn结果的Future；失败时不调用fn，返回的Future传递错误
+//fn返回*Future时，返回的Future在该Future完成时完成（用于串联异步操作）
+//fn异常时返回的Future的错误为*PanicError
+func (fut *Future) Then(fn func(val interface{}) (interface{}, error)) *Future {
+	next := fut.g.NewFuture()
+
+	fut.onDone(func() {
+		//传递错误
+		if fut.err != nil {
+			next.Resolve(nil, fut.err)
+			return
+		}
+
+		val, err := callThen(fn, fut.val)
+		if f, ok := val.(*Future); ok && err == nil {
+			f.onDone(func() {
+				next.Resolve(f.val, f.err)
+			})
+			return
+		}
+
+		next.Resolve(val, err)
+	})
+
+	return next
+}
+
+//调用Then的函数，异常时返回*PanicError
+func callThen(fn func(val interface{}) (interface{}, error), v interface{}) (val interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			val, err = nil, newPanicError(r)
+		}
+	}()
+
+	return fn(v)
+}
+
+//返回在时间段d内未完成时以ErrTimeout失败的Future，完成时传递结果
+//超时之后原来的异步操作仍会继续，其结果被忽略
+func (fut *Future) Timeout(d time.Duration) *Future {
+	next := fut.g.NewFuture()
+
+	fut.onDone(func() {
+		next.Resolve(fut.val, fut.err)
+	})
+	if next.done {
+		return next
+	}
+
+	//到期时通过回调管道发送回模块的goroutine
+	g := fut.g
+	g.pendingGo.Add(1)
+	t := time.AfterFunc(d, func() {
+		g.ChanCb <- func() {
+			next.Resolve(nil, ErrTimeout)
+		}
+	})
+	//记录定时器，关闭Go时停止
+	if g.timeouts == nil {
+		g.timeouts = make(map[*time.Timer]bool)
+	}
+	g.timeouts[t] = true
+
+	//先完成时停止定时器
+	next.onDone(func() {
+		delete(g.timeouts, t)
+		if t.Stop() {
+			g.pendingGo.Add(-1)
+		}
+	})
+
+	return next
+}
+
+//所有Future都成功完成时，返回的Future以结果切片（[]interface{}，与参数顺序相同）完成
+//任意一个失败时，返回的Future以该错误失败；没有Future时立即以空切片完成
+//futs应属于g（回调在同一个goroutine中调用）
+func (g *Go) All(futs ...*Future) *Future {
+	next := g.NewFuture()
+	vals := make([]interface{}, len(futs))
+	remain := len(futs)
+
+	if remain == 0 {
+		next.Resolve(vals, nil)
+		return next
+	}
+
+	for i, fut := range futs {
+		i, fut := i, fut
+		fut.onDone(func() {
+			if fut.err != nil {
+				next.Resolve(nil, fut.err)
+				return
+			}
+
+			vals[i] = fut.val
+			remain--
+			if remain == 0 {
+				next.Resolve(vals, nil)
+			}
+		})
+	}
+
+	return next
+}
+
+//任意一个Future成功完成时，返回的Future以其结果完成
+//全部失败时，返回的Future以最后一个错误失败；没有Future时立即失败
+//futs应属于g（回调在同一个goroutine中调用）
+func (g *Go) Any(futs ...*Future) *Future {
+	next := g.NewFuture()
+	remain := len(futs)
+
+	if remain == 0 {
+		next.Resolve(nil, errors.New("no futures"))
+		return next
+	}
+
+	for _, fut := range futs {
+		fut := fut
+		fut.onDone(func() {
+			if fut.err == nil {
+				next.Resolve(fut.val, nil)
+				return
+			}
+
+			remain--
+			if remain == 0 {
+				next.Resolve(nil, fut.err)
+			}
+		})
+	}
+
+	return next
+}
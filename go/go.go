@@ -2,16 +2,23 @@ package g
 
 import (
 	"container/list"
+	"context"
 	"runtime"
 	"squash/conf"
 	"squash/log"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 //Go类型定义
 type Go struct {
-	ChanCb    chan func() //回调管道，用于传输回调函数
-	pendingGo int         //待处理回调函数计数器
+	ChanCb    chan func()          //回调管道，用于传输回调函数
+	pendingGo atomic.Int64         //待处理回调函数计数器（Stats可以在其他goroutine中读取）
+	pool      *pool                //工作池，为空时每次Go创建一个goroutine
+	ctx       context.Context      //根上下文，GoCtx的上下文从它派生
+	cancel    context.CancelFunc   //取消根上下文
+	timeouts  map[*time.Timer]bool //Future.Timeout未到期的定时器（只在所属goroutine中访问）
 }
 
 //线性（串行）Go类型定义
@@ -34,13 +41,24 @@ func New(l int) *Go {
 	g := new(Go)
 	//创建回调管道
 	g.ChanCb = make(chan func(), l)
+	//创建根上下文
+	g.ctx, g.cancel = context.WithCancel(context.Background())
 	return g
 }
 
 //一般的Go，执行一个比较耗时的操作，执行完成后将回调函数通过回调管道发送回原goroutine执行
+//使用工作池时，任务队列已满按创建时的策略阻塞或拒绝（拒绝时f和cb都不执行，输出错误日志）
 func (g *Go) Go(f func(), cb func()) {
+	//使用工作池
+	if g.pool != nil {
+		if err := g.submit(f, cb, g.pool.block); err != nil {
+			log.Error("%v (workers %v, queue %v): f and cb dropped", err, g.pool.workers, cap(g.pool.tasks))
+		}
+		return
+	}
+
 	//增加待处理回调函数计数器
-	g.pendingGo++
+	g.pendingGo.Add(1)
 
 	//在一个新的goroutine中执行
 	go func() {
@@ -69,7 +87,7 @@ func (g *Go) Go(f func(), cb func()) {
 func (g *Go) Cb(cb func()) {
 	defer func() {
 		//减少待处理回调函数计数器
-		g.pendingGo--
+		g.pendingGo.Add(-1)
 		//处理异常
 		if r := recover(); r != nil {
 			if conf.LenStackBuf > 0 { //配置了调用栈踪迹缓冲长度，将当前goroutine的调用栈踪迹格式化后写入到buf中
@@ -90,10 +108,26 @@ func (g *Go) Cb(cb func()) {
 
 //关闭Go
 func (g *Go) Close() {
+	//取消根上下文，通知GoCtx中的f尽快返回
+	g.cancel()
+
+	//停止未到期的超时定时器，不再等待它们到期
+	for t := range g.timeouts {
+		if t.Stop() {
+			g.pendingGo.Add(-1)
+		}
+	}
+	g.timeouts = nil
+
 	//有待处理的回调函数，从管道中读出来执行
-	for g.pendingGo > 0 {
+	for g.pendingGo.Load() > 0 {
 		g.Cb(<-g.ChanCb)
 	}
+
+	//停止工作goroutine
+	if g.pool != nil {
+		close(g.pool.tasks)
+	}
 }
 
 //创建线性上下文
@@ -110,7 +144,7 @@ func (g *Go) NewLinearContext() *LinearContext {
 //线性上下文的Go（串行）
 func (c *LinearContext) Go(f func(), cb func()) {
 	//增加待处理回调函数计数器
-	c.g.pendingGo++
+	c.g.pendingGo.Add(1)
 	//链表加锁
 	c.mutexLinearGo.Lock()
 	//向链表添加元素
@@ -0,0 +1,98 @@
+package g
+
+import (
+	"container/list"
+	"runtime"
+	"squash/conf"
+	"squash/log"
+	"sync"
+)
+
+//按键的线性上下文，相同键的Go按顺序串行执行，不同键的Go并发执行
+//每个有待执行Go的键使用一个goroutine和一个队列，队列执行完后释放，空闲的键不占用资源
+type KeyedLinearContext struct {
+	g      *Go                        //一个Go
+	queues map[interface{}]*list.List //键->待执行的Go（队首为正在执行的Go）
+	mutex  sync.Mutex                 //队列互斥锁
+}
+
+//创建按键的线性上下文
+func (g *Go) NewKeyedLinearContext() *KeyedLinearContext {
+	c := new(KeyedLinearContext)
+	c.g = g
+	c.queues = make(map[interface{}]*list.List)
+	return c
+}
+
+//按键的线性上下文的Go，key相同的Go按调用顺序串行执行（key需要可以作为map的键）
+func (c *KeyedLinearContext) Go(key interface{}, f func(), cb func()) {
+	//增加待处理回调函数计数器
+	c.g.pendingGo.Add(1)
+
+	c.mutex.Lock()
+	q, ok := c.queues[key]
+	if ok {
+		//该键有正在执行的Go，排队
+		q.PushBack(&LinearGo{f: f, cb: cb})
+		c.mutex.Unlock()
+		return
+	}
+
+	//该键空闲，创建队列并启动goroutine
+	q = list.New()
+	q.PushBack(&LinearGo{f: f, cb: cb})
+	c.queues[key] = q
+	c.mutex.Unlock()
+
+	go c.run(key, q)
+}
+
+//依次执行键的队列，队列为空时释放
+func (c *KeyedLinearContext) run(key interface{}, q *list.List) {
+	for {
+		c.mutex.Lock()
+		e := q.Front().Value.(*LinearGo)
+		c.mutex.Unlock()
+
+		c.exec(e)
+
+		c.mutex.Lock()
+		q.Remove(q.Front())
+		//队列为空，释放
+		if q.Len() == 0 {
+			delete(c.queues, key)
+			c.mutex.Unlock()
+			return
+		}
+		c.mutex.Unlock()
+	}
+}
+
+//执行函数，将回调发送到回调管道中
+func (c *KeyedLinearContext) exec(e *LinearGo) {
+	defer func() {
+		//当f执行完成后，将回调发送到回调管道中
+		c.g.ChanCb <- e.cb
+		//处理异常
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 { //配置了调用栈踪迹缓冲长度，将当前goroutine的调用栈踪迹格式化后写入到buf中
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Error("%v: %s", r, buf[:l])
+			} else {
+				log.Error("%v", r)
+			}
+		}
+	}()
+
+	//执行函数
+	e.f()
+}
+
+//有待执行Go的键的数量
+func (c *KeyedLinearContext) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.queues)
+}
@@ -0,0 +1,170 @@
+package g
+
+import (
+	"errors"
+	"runtime"
+	"squash/conf"
+	"squash/log"
+	"sync/atomic"
+	"time"
+)
+
+//工作池已满
+var ErrPoolFull = errors.New("go pool is full")
+
+//工作池的任务
+type poolTask struct {
+	f  func() //执行函数
+	cb func() //回调函数
+}
+
+//工作池，固定数量的goroutine从任务队列中读取任务执行
+type pool struct {
+	tasks     chan *poolTask //任务队列
+	workers   int            //工作goroutine数量
+	block     bool           //队列已满时Go是否阻塞，为false时拒绝
+	running   atomic.Int64   //正在执行的任务数
+	submitted atomic.Uint64  //提交的任务数
+	completed atomic.Uint64  //完成的任务数
+	rejected  atomic.Uint64  //拒绝的任务数
+	execTime  atomic.Int64   //总执行时间（纳秒）
+	maxExec   atomic.Int64   //最长执行时间（纳秒）
+}
+
+//Go的统计信息
+type Stats struct {
+	Workers     int           //工作goroutine数量，为0时每次Go创建一个goroutine
+	QueueLen    int           //任务队列中等待执行的任务数
+	QueueCap    int           //任务队列长度
+	Running     int           //正在执行的任务数
+	Pending     int           //回调还未执行的Go数量
+	Submitted   uint64        //提交的任务数
+	Completed   uint64        //完成的任务数
+	Rejected    uint64        //拒绝的任务数
+	ExecTime    time.Duration //总执行时间
+	MaxExecTime time.Duration //最长执行时间
+}
+
+//创建使用工作池的Go，l为回调管道长度，workers为工作goroutine数量，queueLen为任务队列长度
+//任务队列已满时，block为true则Go阻塞直到队列有空位，否则拒绝任务（f和cb都不执行）
+//阻塞模式下回调管道已满会导致工作goroutine和调用者互相等待，回调管道长度应不小于workers+queueLen
+func NewPool(l int, workers int, queueLen int, block bool) *Go {
+	//至少一个工作goroutine
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueLen < 0 {
+		queueLen = 0
+	}
+
+	g := New(l)
+	p := new(pool)
+	p.tasks = make(chan *poolTask, queueLen)
+	p.workers = workers
+	p.block = block
+	g.pool = p
+
+	//启动工作goroutine
+	for i := 0; i < workers; i++ {
+		go g.work()
+	}
+
+	return g
+}
+
+//工作goroutine，任务队列关闭时退出
+func (g *Go) work() {
+	for t := range g.pool.tasks {
+		g.exec(t)
+	}
+}
+
+//执行任务，将回调发送到回调管道中
+func (g *Go) exec(t *poolTask) {
+	p := g.pool
+	p.running.Add(1)
+	start := time.Now()
+
+	defer func() {
+		//统计执行时间
+		d := int64(time.Since(start))
+		p.execTime.Add(d)
+		for {
+			max := p.maxExec.Load()
+			if d <= max || p.maxExec.CompareAndSwap(max, d) {
+				break
+			}
+		}
+		p.running.Add(-1)
+		p.completed.Add(1)
+
+		//当f执行完成后，将回调发送到回调管道中
+		g.ChanCb <- t.cb
+		//处理异常
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 { //配置了调用栈踪迹缓冲长度，将当前goroutine的调用栈踪迹格式化后写入到buf中
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Error("%v: %s", r, buf[:l])
+			} else {
+				log.Error("%v", r)
+			}
+		}
+	}()
+
+	t.f()
+}
+
+//提交任务到工作池，block为false且队列已满时返回ErrPoolFull
+func (g *Go) submit(f func(), cb func(), block bool) error {
+	p := g.pool
+	t := &poolTask{f: f, cb: cb}
+
+	if block {
+		p.tasks <- t
+	} else {
+		select {
+		case p.tasks <- t:
+		default:
+			p.rejected.Add(1)
+			return ErrPoolFull
+		}
+	}
+
+	//增加待处理回调函数计数器
+	g.pendingGo.Add(1)
+	p.submitted.Add(1)
+	return nil
+}
+
+//尝试执行一个比较耗时的操作，工作池的任务队列已满时不阻塞，返回ErrPoolFull（f和cb都不执行）
+//没有使用工作池时与Go相同
+func (g *Go) TryGo(f func(), cb func()) error {
+	if g.pool == nil {
+		g.Go(f, cb)
+		return nil
+	}
+
+	return g.submit(f, cb, false)
+}
+
+//获取统计信息
+func (g *Go) Stats() Stats {
+	s := Stats{Pending: int(g.pendingGo.Load())}
+
+	p := g.pool
+	if p == nil {
+		return s
+	}
+
+	s.Workers = p.workers
+	s.QueueLen = len(p.tasks)
+	s.QueueCap = cap(p.tasks)
+	s.Running = int(p.running.Load())
+	s.Submitted = p.submitted.Load()
+	s.Completed = p.completed.Load()
+	s.Rejected = p.rejected.Load()
+	s.ExecTime = time.Duration(p.execTime.Load())
+	s.MaxExecTime = time.Duration(p.maxExec.Load())
+	return s
+}
@@ -5,6 +5,7 @@ import (
 	"squash/conf"
 	"squash/log"
 	"sync"
+	"sync/atomic"
 )
 
 //模块接口
@@ -24,6 +25,9 @@ type module struct {
 //模块数组，用于保存注册的模块
 var mods []*module
 
+//就绪标志，所有模块初始化完成后为1
+var ready int32
+
 //运行模块
 func run(m *module) {
 	//等待组+1
@@ -51,6 +55,11 @@ func destroy(m *module) {
 
 	//调用模块的销毁方法
 	m.mi.OnDestroy()
+
+	//使用骨架的模块，检查定时器组泄漏
+	if d, ok := m.mi.(interface{ destroyed() }); ok {
+		d.destroyed()
+	}
 }
 
 //注册模块
@@ -76,10 +85,21 @@ func Init() {
 	for i := 0; i < len(mods); i++ {
 		go run(mods[i])
 	}
+
+	//标记为就绪
+	atomic.StoreInt32(&ready, 1)
+}
+
+//是否就绪（Init已完成且尚未Destroy）
+func Ready() bool {
+	return atomic.LoadInt32(&ready) == 1
 }
 
 //销毁已注册模块
 func Destroy() {
+	//取消就绪标记
+	atomic.StoreInt32(&ready, 0)
+
 	//遍历所有注册的模块（反序，从后往前）
 	for i := len(mods) - 1; i >= 0; i-- {
 		m := mods[i]
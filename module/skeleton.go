@@ -1,21 +1,42 @@
 package module
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"squash/chanrpc"
+	"squash/conf"
 	"squash/console"
 	"squash/go" //包名实际为g
 	"squash/log"
 	"squash/timer"
+	"sync"
 	"time"
 )
 
+//每轮最多连续处理的高优先级调用数，避免普通调用饿死
+const highPriorityBurst = 16
+
+//默认任务存储，所有未指定任务存储的模块共享
+var (
+	defaultJobStore     timer.JobStore
+	defaultJobStoreOnce sync.Once
+)
+
 //骨架
 type Skeleton struct {
 	GoLen              int               //Go管道长度
+	GoWorkers          int               //Go工作池的工作goroutine数量，大于0时Go使用工作池，否则每次Go创建一个goroutine
+	GoQueueLen         int               //Go工作池的任务队列长度
+	GoBlock            bool              //Go工作池的任务队列已满时Go是否阻塞，为false时拒绝
 	TimerDispatcherLen int               //定时器分发器管道长度
+	TimerWheelTick     time.Duration     //定时器时间轮精度，大于0时定时器使用时间轮（适用于大量定时器），否则使用runtime定时器
+	Clock              timer.Clock       //定时器时钟，不为空时优先使用（如测试中使用timer.FakeClock）
+	JobStore           timer.JobStore    //任务存储，为空时使用conf.JobStorePath的文件存储，conf.JobStorePath也为空时不持久化
 	ChanRPCServer      *chanrpc.Server   //rpc服务器引用（外部传入）
 	g                  *g.Go             //leaf的Go机制
 	dispatcher         *timer.Dispatcher //定时器分发器
+	scheduler          *timer.Scheduler  //任务调度器，第一次注册任务时创建
 	server             *chanrpc.Server   //rpc服务器引用(内部引用)
 	commandServer      *chanrpc.Server   //命令rpc服务器引用
 }
@@ -26,15 +47,31 @@ func (s *Skeleton) Init() {
 	if s.GoLen <= 0 {
 		s.GoLen = 0
 	}
+	//工作池阻塞模式下，Go管道需要能容纳所有工作goroutine和任务队列中任务的回调，否则工作goroutine和模块的goroutine会互相等待
+	if s.GoWorkers > 0 && s.GoBlock && s.GoLen < s.GoWorkers+s.GoQueueLen {
+		panic("GoLen must be at least GoWorkers+GoQueueLen when GoBlock is set")
+	}
 
 	//检查定时器分发器管道长度
 	if s.TimerDispatcherLen <= 0 {
 		s.TimerDispatcherLen = 0
 	}
 
-	s.g = g.New(s.GoLen)                                     //创建Go
-	s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen) //创建分发器
-	s.server = s.ChanRPCServer                               //外部传入的，内部引用
+	//创建Go
+	if s.GoWorkers > 0 {
+		s.g = g.NewPool(s.GoLen, s.GoWorkers, s.GoQueueLen, s.GoBlock)
+	} else {
+		s.g = g.New(s.GoLen)
+	}
+	//创建分发器
+	if s.Clock != nil {
+		s.dispatcher = timer.NewDispatcherWithClock(s.TimerDispatcherLen, s.Clock)
+	} else if s.TimerWheelTick > 0 {
+		s.dispatcher = timer.NewWheelDispatcher(s.TimerDispatcherLen, s.TimerWheelTick)
+	} else {
+		s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen)
+	}
+	s.server = s.ChanRPCServer //外部传入的，内部引用
 	//外部传入的为空，内部创建一个
 	if s.server == nil {
 		s.server = chanrpc.NewServer(0)
@@ -50,22 +87,22 @@ func (s *Skeleton) Init() {
 //4.timer（用于定时器）
 func (s *Skeleton) Run(closeSig chan bool) {
 	for { //死循环
+		//优先处理高优先级调用
+		s.execHighPriority()
+
 		select {
 		case <-closeSig: //读取关闭信号
 			s.commandServer.Close() //关闭命令rpc服务器
 			s.server.Close()        //关闭rpc服务器
 			s.g.Close()             //关闭Go
+			s.dispatcher.Close()    //关闭定时器分发器
 			return
+		case ci := <-s.server.ChanCallHigh: //从rpc服务器读取高优先级调用信息
+			s.exec(s.server, ci)
 		case ci := <-s.server.ChanCall: //从rpc服务器读取调用信息
-			err := s.server.Exec(ci) //执行调用
-			if err != nil {
-				log.Error("%v", err)
-			}
+			s.exec(s.server, ci)
 		case ci := <-s.commandServer.ChanCall: //从命令rpc服务器读取调用信息
-			err := s.commandServer.Exec(ci) //执行命令调用
-			if err != nil {
-				log.Error("%v", err)
-			}
+			s.exec(s.commandServer, ci) //执行命令调用
 		case cb := <-s.g.ChanCb: //从Go的回调管道中读取回调函数
 			s.g.Cb(cb) //执行回调函数（不用自己写 d.Cb(<-d.ChanCb)了 ）
 		case t := <-s.dispatcher.ChanTimer: //从分发器中读取到时定时器
@@ -74,6 +111,31 @@ func (s *Skeleton) Run(closeSig chan bool) {
 	}
 }
 
+//连续处理已到达的高优先级调用，最多highPriorityBurst个，之后让出给其他管道
+func (s *Skeleton) execHighPriority() {
+	for i := 0; i < highPriorityBurst; i++ {
+		select {
+		case ci, ok := <-s.server.ChanCallHigh:
+			//管道已关闭
+			if !ok {
+				return
+			}
+
+			s.exec(s.server, ci)
+		default:
+			return
+		}
+	}
+}
+
+//执行rpc调用
+func (s *Skeleton) exec(server *chanrpc.Server, ci *chanrpc.CallInfo) {
+	err := server.Exec(ci) //执行调用
+	if err != nil {
+		log.Error("%v", err)
+	}
+}
+
 //注册定时器
 func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
 	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
@@ -83,6 +145,34 @@ func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
 	return s.dispatcher.AfterFunc(d, cb)
 }
 
+//注册周期定时器
+func (s *Skeleton) TickFunc(d time.Duration, cb func()) *timer.Timer {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.TickFunc(d, cb)
+}
+
+//创建定时器组，模块销毁（OnDestroy之后）时组中还有未到期的定时器会输出泄漏日志
+func (s *Skeleton) NewTimerGroup(name string) *timer.Group {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.NewGroup(name)
+}
+
+//模块销毁之后调用，检查定时器组泄漏
+func (s *Skeleton) destroyed() {
+	s.dispatcher.CloseGroups()
+}
+
+//获取定时器时钟的当前时间
+func (s *Skeleton) Now() time.Time {
+	return s.dispatcher.Now()
+}
+
 //注册cron
 func (s *Skeleton) CronFunc(expr string, cb func()) (*timer.Cron, error) {
 	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
@@ -92,7 +182,56 @@ func (s *Skeleton) CronFunc(expr string, cb func()) (*timer.Cron, error) {
 	return s.dispatcher.CronFunc(expr, cb)
 }
 
-//一般的go
+//注册任务，停机期间错过的运行按policy补运行
+//任务名在共享同一个任务存储的所有模块中唯一
+func (s *Skeleton) JobFunc(name string, expr string, policy timer.CatchUpPolicy, cb func()) (*timer.Job, error) {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	//创建任务调度器
+	if s.scheduler == nil {
+		store := s.JobStore
+		if store == nil && conf.JobStorePath != "" {
+			defaultJobStoreOnce.Do(func() {
+				defaultJobStore = timer.NewFileJobStore(conf.JobStorePath)
+			})
+			store = defaultJobStore
+		}
+		s.scheduler = timer.NewScheduler(s.dispatcher, store)
+	}
+
+	return s.scheduler.JobFunc(name, expr, policy, cb)
+}
+
+//注册查看任务状态的命令
+func (s *Skeleton) RegisterJobsCommand(name string) {
+	s.RegisterCommand(name, "show scheduled jobs", func(args []interface{}) interface{} {
+		if s.scheduler == nil {
+			return "no jobs"
+		}
+
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "%-20v %-20v %-6v %-25v %-25v %-6v %-6v %v", "Name", "Expr", "Policy", "Last Run", "Next Run", "Runs", "Missed", "Error")
+		for _, st := range s.scheduler.Status() {
+			fmt.Fprintf(&b, "\r\n%-20v %-20v %-6v %-25v %-25v %-6v %-6v %v",
+				st.Name, st.Expr, st.Policy, formatJobTime(st.LastRun), formatJobTime(st.NextRun), st.Runs, st.Missed, st.LastErr)
+		}
+
+		return b.String()
+	})
+}
+
+//格式化任务时间，零值显示为-
+func formatJobTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+//一般的go，使用工作池且不阻塞（GoBlock为false）时，任务队列已满的Go被丢弃并输出错误日志，需要处理拒绝时使用TryGo
 func (s *Skeleton) Go(f func(), cb func()) {
 	if s.GoLen == 0 { //如果Go管道为空
 		panic("invalid GoLen") //直接panic
@@ -101,6 +240,58 @@ func (s *Skeleton) Go(f func(), cb func()) {
 	s.g.Go(f, cb) //调用骨架中创建的g(Go类型)的Go函数
 }
 
+//可取消的Go，f的ctx在模块关闭或调用返回的取消函数时取消，cb以f的错误（异常时为*g.PanicError）调用
+func (s *Skeleton) GoCtx(f func(ctx context.Context) error, cb func(err error)) context.CancelFunc {
+	if s.GoLen == 0 { //如果Go管道为空
+		panic("invalid GoLen") //直接panic
+	}
+
+	return s.g.GoCtx(f, cb)
+}
+
+//尝试执行Go，使用工作池且任务队列已满时返回g.ErrPoolFull
+func (s *Skeleton) TryGo(f func(), cb func()) error {
+	if s.GoLen == 0 { //如果Go管道为空
+		panic("invalid GoLen") //直接panic
+	}
+
+	return s.g.TryGo(f, cb)
+}
+
+//获取Go的统计信息
+func (s *Skeleton) GoStats() g.Stats {
+	return s.g.Stats()
+}
+
+//在其他goroutine中执行f，返回结果的Future，Future的回调在模块的goroutine中执行
+func (s *Skeleton) Async(f func() (interface{}, error)) *g.Future {
+	if s.GoLen == 0 { //如果Go管道为空
+		panic("invalid GoLen") //直接panic
+	}
+
+	return s.g.Async(f)
+}
+
+//发起rpc异步调用，返回调用结果的Future（rpc函数有一个返回值）
+//c.ChanAsynRet需要在模块的goroutine中读取并执行c.Cb
+func (s *Skeleton) AsynCall(c *chanrpc.Client, id interface{}, args ...interface{}) *g.Future {
+	if s.GoLen == 0 { //如果Go管道为空
+		panic("invalid GoLen") //直接panic
+	}
+
+	return s.g.AsynCall(c, id, args...)
+}
+
+//所有Future都成功完成时以结果切片完成的Future
+func (s *Skeleton) All(futs ...*g.Future) *g.Future {
+	return s.g.All(futs...)
+}
+
+//任意一个Future成功完成时以其结果完成的Future
+func (s *Skeleton) Any(futs ...*g.Future) *g.Future {
+	return s.g.Any(futs...)
+}
+
 //创建线性上下文，再执行线性上下文的Go
 func (s *Skeleton) NewLinearContext() *g.LinearContext {
 	if s.GoLen == 0 {
@@ -110,6 +301,15 @@ func (s *Skeleton) NewLinearContext() *g.LinearContext {
 	return s.g.NewLinearContext()
 }
 
+//创建按键的线性上下文，相同键（如玩家ID）的Go串行执行
+func (s *Skeleton) NewKeyedLinearContext() *g.KeyedLinearContext {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	return s.g.NewKeyedLinearContext()
+}
+
 //向管道RPC注册函数
 func (s *Skeleton) RegisterChanRPC(id interface{}, f interface{}) {
 	if s.ChanRPCServer == nil { //外部没有传入RPC服务器
@@ -0,0 +1,20 @@
+package network
+
+import (
+	"errors"
+)
+
+//未认证错误，处理器需要认证而代理未认证时，路由返回该错误
+var ErrNotAuthenticated = errors.New("not authenticated")
+
+//认证状态接口，由路由时传入的用户数据（如gate的代理）实现
+type Authenticator interface {
+	Authenticated() bool //是否已认证
+}
+
+//屏障处理器接口（可选），处理器实现该接口后可以将消息设置为屏障消息（如登录、握手消息）
+//登录等消息通常路由到其他goroutine中处理，处理完成之前客户端紧接着发送的消息可能已经被路由并因未认证而被拒绝
+//gate的代理路由屏障消息后暂停读取，直到处理函数设置认证状态、协议版本或者恢复读取，之后的消息按处理结果路由和解码
+type BarrierProcessor interface {
+	IsBarrier(msg interface{}) bool //是否为屏障消息（msg可以是*SeqMsg）
+}
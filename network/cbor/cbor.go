@@ -0,0 +1,73 @@
+package cbor
+
+import (
+	"github.com/fxamacker/cbor/v2"
+	"squash/network/codec"
+)
+
+//消息信封格式
+const (
+	EnvelopeID   = codec.EnvelopeID   //以注册顺序作为消息ID（默认）
+	EnvelopeName = codec.EnvelopeName //以消息名字作为消息ID
+)
+
+//处理器（消息体使用cbor编码）
+//EnvelopeID格式：
+// ---------------------
+// | id | cbor message |
+// ---------------------
+//启用序号后：
+// ---------------------------
+// | id | seq | cbor message |
+// ---------------------------
+//EnvelopeName格式为cbor map：{"msgName": message}，启用序号后为{"seq": seq, "msgName": message}
+type Processor struct {
+	*codec.Processor
+}
+
+//消息信息
+type MsgInfo = codec.MsgInfo
+
+//消息处理函数
+type MsgHandler = codec.MsgHandler
+
+//创建一个处理器
+func NewProcessor() *Processor {
+	return &Processor{codec.NewProcessor(cborCodec{})}
+}
+
+//确定性编码模式（RFC 8949 4.2.1），map按键排序以保证结果确定
+var encMode, _ = cbor.CoreDetEncOptions().EncMode()
+
+//cbor编码器
+type cborCodec struct{}
+
+//实现codec.Codec接口的Name方法
+func (cborCodec) Name() string {
+	return "cbor"
+}
+
+//实现codec.Codec接口的Marshal方法
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	return encMode.Marshal(v)
+}
+
+//实现codec.Codec接口的Unmarshal方法
+func (cborCodec) Unmarshal(data []byte, v interface{}) error {
+	return cbor.Unmarshal(data, v)
+}
+
+//实现codec.Codec接口的UnmarshalMap方法
+func (cborCodec) UnmarshalMap(data []byte) (map[string][]byte, error) {
+	var m map[string]cbor.RawMessage
+	if err := cbor.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string][]byte, len(m))
+	for k, v := range m {
+		raw[k] = v
+	}
+
+	return raw, nil
+}
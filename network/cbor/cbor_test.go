@@ -0,0 +1,64 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"squash/network"
+	"strings"
+	"testing"
+)
+
+type Hello struct {
+	Name string
+}
+
+type Bye struct {
+	Code int32
+}
+
+//参考编码（RFC 8949），信封格式的行为由network/codec测试
+func TestVectors(t *testing.T) {
+	for _, v := range []struct {
+		name       string
+		msg        interface{}
+		data       string
+		decodeOnly bool //其他实现的编码，只测试解码
+	}{
+		//id(2字节) + map(1){text "Code": negative int -1}
+		{"int32", &Bye{Code: -1}, "0001 a1 64436f6465 20", false},
+		//map(2){"seq": unsigned 7, "Hello": map(1){"Name": text "hi"}}，确定性编码中键按编码后的字节序排序（"seq"较短在前）
+		{"name seq", &network.SeqMsg{Seq: 7, Msg: &Hello{Name: "hi"}}, "a2 63736571 07 6548656c6c6f a1 644e616d65 626869", false},
+		//整数使用非最短格式，键未排序：map(2){"Bye": {"Code": int8 -1}, "seq": uint32 7}
+		{"non-canonical", &network.SeqMsg{Seq: 7, Msg: &Bye{Code: -1}}, "a2 63427965 a1 64436f6465 3800 63736571 1a00000007", true},
+	} {
+		p := NewProcessor()
+		if _, ok := v.msg.(*network.SeqMsg); ok {
+			p.SetEnvelope(EnvelopeName)
+		}
+		p.Register(&Hello{})
+		p.Register(&Bye{})
+
+		want, err := hex.DecodeString(strings.Join(strings.Fields(v.data), ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !v.decodeOnly {
+			data, err := p.Marshal(v.msg)
+			if err != nil {
+				t.Fatalf("%v: %v", v.name, err)
+			}
+			if got := bytes.Join(data, nil); !bytes.Equal(got, want) {
+				t.Errorf("%v: marshal got %x, want %x", v.name, got, want)
+			}
+		}
+
+		msg, err := p.Unmarshal(want)
+		if err != nil {
+			t.Fatalf("%v: %v", v.name, err)
+		}
+		if !reflect.DeepEqual(msg, v.msg) {
+			t.Errorf("%v: unmarshal got %#v, want %#v", v.name, msg, v.msg)
+		}
+	}
+}
@@ -0,0 +1,529 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"squash/chanrpc"
+	"squash/log"
+	"squash/network"
+)
+
+//编码器，由具体的编码格式（如msgpack、cbor）实现
+type Codec interface {
+	Name() string                                        //格式名，用于日志和错误信息
+	Marshal(v interface{}) ([]byte, error)               //编码，map需要按键排序以保证结果确定
+	Unmarshal(data []byte, v interface{}) error          //解码
+	UnmarshalMap(data []byte) (map[string][]byte, error) //将map解码为键->未解码的值
+}
+
+//消息信封格式
+const (
+	EnvelopeID   = iota //以注册顺序作为消息ID（默认）
+	EnvelopeName        //以消息名字作为消息ID
+)
+
+//序号使用的键（EnvelopeName格式）
+const seqKey = "seq"
+
+//处理器，消息的注册、路由和信封格式与编码格式无关，消息体由编码器编码
+//EnvelopeID格式：
+// ----------------
+// | id | message |
+// ----------------
+//启用序号后：
+// ----------------------
+// | id | seq | message |
+// ----------------------
+//EnvelopeName格式为编码格式的map：{"msgName": message}，启用序号后为{"seq": seq, "msgName": message}
+type Processor struct {
+	network.MiddlewareChain //中间件链
+
+	codec        Codec                   //编码器
+	authRequired bool                    //是否需要认证
+	envelope     int                     //信封格式
+	littleEndian bool                    //是否小端
+	seq          bool                    //是否启用序号
+	msgInfo      []*MsgInfo              //消息信息切片
+	msgID        map[reflect.Type]uint16 //消息ID映射
+	msgName      map[string]uint16       //消息名字映射
+}
+
+//消息信息
+type MsgInfo struct {
+	msgType    reflect.Type   //消息类型
+	msgName    string         //消息名字
+	msgRouter  chanrpc.Router //处理消息的路由器（rpc服务器或分片路由器）
+	msgHandler MsgHandler     //消息处理函数
+
+	allowBeforeAuth bool                //是否允许在认证前发送
+	barrier         bool                //是否为屏障消息
+	versions        network.MsgVersions //旧版本和弃用状态
+}
+
+//消息处理函数
+type MsgHandler func([]interface{})
+
+//创建一个处理器
+func NewProcessor(codec Codec) *Processor {
+	//创建处理器
+	p := new(Processor)
+	//保存编码器
+	p.codec = codec
+	//信封格式默认为消息ID
+	p.envelope = EnvelopeID
+	//字节序默认大端
+	p.littleEndian = false
+	//创建消息ID映射
+	p.msgID = make(map[reflect.Type]uint16)
+	//创建消息名字映射
+	p.msgName = make(map[string]uint16)
+
+	return p
+}
+
+//设置信封格式（EnvelopeID或EnvelopeName）
+func (p *Processor) SetEnvelope(envelope int) {
+	p.envelope = envelope
+}
+
+//设置字节序是否小端
+func (p *Processor) SetByteOrder(littleEndian bool) {
+	p.littleEndian = littleEndian
+}
+
+//设置是否启用序号（请求/响应关联）
+func (p *Processor) SetSeq(seq bool) {
+	p.seq = seq
+}
+
+//注册消息
+func (p *Processor) Register(msg interface{}) {
+	//获取消息类型
+	msgType := reflect.TypeOf(msg)
+
+	//判断消息的合法性（不能为空，需要是指针）
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.Fatal("%v message pointer required", p.codec.Name())
+	}
+
+	//获取消息本身（不是指针）的名字
+	msgName := msgType.Elem().Name()
+
+	//获取失败
+	if msgName == "" {
+		log.Fatal("unnamed %v message", p.codec.Name())
+	}
+
+	//消息名字不能与序号的键相同
+	if msgName == seqKey {
+		log.Fatal("%v message name %v is reserved", p.codec.Name(), seqKey)
+	}
+
+	//消息已注册
+	if _, ok := p.msgID[msgType]; ok {
+		log.Fatal("message %s is already registered", msgType)
+	}
+
+	//消息名字已注册
+	if _, ok := p.msgName[msgName]; ok {
+		log.Fatal("message name %v is already registered", msgName)
+	}
+
+	//消息切片已满
+	if len(p.msgInfo) >= math.MaxUint16 {
+		log.Fatal("too many %v messages (max = %v)", p.codec.Name(), math.MaxUint16)
+	}
+
+	//新建一个消息信息
+	i := new(MsgInfo)
+	//保存消息类型
+	i.msgType = msgType
+	//保存消息名字
+	i.msgName = msgName
+	//保存消息信息到切片中
+	p.msgInfo = append(p.msgInfo, i)
+	//保存消息ID到映射中
+	p.msgID[msgType] = uint16(len(p.msgInfo) - 1)
+	//保存消息名字到映射中
+	p.msgName[msgName] = uint16(len(p.msgInfo) - 1)
+}
+
+//设置路由
+func (p *Processor) SetRouter(msg interface{}, msgRouter chanrpc.Router) {
+	//获取消息类型
+	msgType := reflect.TypeOf(msg)
+	//获取消息ID
+	id, ok := p.msgID[msgType]
+
+	//消息未注册
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+	}
+
+	//路由器不能为空
+	if chanrpc.IsNilRouter(msgRouter) {
+		log.Fatal("message %s router is nil", msgType)
+	}
+
+	//保存路由器引用
+	p.msgInfo[id].msgRouter = msgRouter
+}
+
+//设置消息处理函数
+func (p *Processor) SetHandler(msg interface{}, msgHandler MsgHandler) {
+	//获取消息的类型
+	msgType := reflect.TypeOf(msg)
+	//获取消息ID
+	id, ok := p.msgID[msgType]
+
+	//消息未注册
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+	}
+
+	//保存消息处理函数
+	p.msgInfo[id].msgHandler = msgHandler
+}
+
+//设置是否需要认证
+//需要认证时，未认证的代理（network.Authenticator）只能发送允许在认证前发送的消息，其他消息路由时返回network.ErrNotAuthenticated
+func (p *Processor) SetAuthRequired(required bool) {
+	p.authRequired = required
+}
+
+//设置消息允许在认证前发送（如登录消息）
+func (p *Processor) SetAllowBeforeAuth(msg interface{}) {
+	//获取消息类型
+	msgType := reflect.TypeOf(msg)
+	//获取消息ID
+	id, ok := p.msgID[msgType]
+
+	//消息未注册
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+	}
+
+	//允许在认证前发送
+	p.msgInfo[id].allowBeforeAuth = true
+}
+
+//设置屏障消息（如登录、握手消息），gate的代理路由该消息后暂停读取，直到处理函数设置认证状态或协议版本（参见network.BarrierProcessor）
+func (p *Processor) SetBarrier(msg interface{}) {
+	//获取消息类型
+	msgType := reflect.TypeOf(msg)
+	//获取消息ID
+	id, ok := p.msgID[msgType]
+
+	//消息未注册
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+	}
+
+	//屏障消息
+	p.msgInfo[id].barrier = true
+}
+
+//实现network.BarrierProcessor接口的IsBarrier方法
+func (p *Processor) IsBarrier(msg interface{}) bool {
+	//带序号的消息，取出消息
+	if sm, ok := msg.(*network.SeqMsg); ok {
+		msg = sm.Msg
+	}
+
+	id, ok := p.msgID[reflect.TypeOf(msg)]
+	return ok && p.msgInfo[id].barrier
+}
+
+//路由，消息经过路由中间件后再分发
+func (p *Processor) Route(msg interface{}, userData interface{}) error {
+	return p.ApplyRoute(msg, userData, p.route)
+}
+
+//分发消息
+func (p *Processor) route(msg interface{}, userData interface{}) error {
+	//带序号的消息，取出序号和消息
+	var seq uint32
+	if sm, ok := msg.(*network.SeqMsg); ok {
+		seq = sm.Seq
+		msg = sm.Msg
+	}
+
+	//获取消息类型
+	msgType := reflect.TypeOf(msg)
+	//获取消息ID
+	id, ok := p.msgID[msgType]
+
+	//判断消息是否已经注册
+	if !ok {
+		return fmt.Errorf("message %s not registered", msgType)
+	}
+
+	//获取消息信息
+	i := p.msgInfo[id]
+
+	//需要认证并且该消息不允许在认证前发送，检查代理是否已认证
+	if p.authRequired && !i.allowBeforeAuth {
+		if a, ok := userData.(network.Authenticator); ok && !a.Authenticated() {
+			return network.ErrNotAuthenticated
+		}
+	}
+
+	//调用消息处理函数
+	if i.msgHandler != nil {
+		i.msgHandler([]interface{}{msg, userData, seq})
+	}
+
+	//rpc服务器自己发起调用
+	if i.msgRouter != nil {
+		i.msgRouter.Go(msgType, msg, userData, seq)
+	}
+
+	return nil
+}
+
+//解码消息（最新版本）
+func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
+	return p.UnmarshalVersion(data, 0)
+}
+
+//按客户端的协议版本解码消息，旧版本消息转换为最新版本后返回，version为0时使用最新版本
+func (p *Processor) UnmarshalVersion(data []byte, version uint32) (interface{}, error) {
+	//以消息名字作为消息ID
+	if p.envelope == EnvelopeName {
+		return p.unmarshalName(data, version)
+	}
+
+	//消息ID和序号所占的字节数
+	headLen := 2
+	if p.seq {
+		headLen = 6
+	}
+
+	//消息过短
+	if len(data) < headLen {
+		return nil, fmt.Errorf("%v data too short", p.codec.Name())
+	}
+
+	var id uint16
+	var seq uint32
+
+	//获取消息ID和序号
+	if p.littleEndian {
+		id = binary.LittleEndian.Uint16(data)
+		if p.seq {
+			seq = binary.LittleEndian.Uint32(data[2:])
+		}
+	} else {
+		id = binary.BigEndian.Uint16(data)
+		if p.seq {
+			seq = binary.BigEndian.Uint32(data[2:])
+		}
+	}
+
+	//ID超出消息切片长度
+	if id >= uint16(len(p.msgInfo)) {
+		return nil, fmt.Errorf("message id %v not registered", id)
+	}
+
+	//按协议版本解码data
+	msg, err := p.decode(p.msgInfo[id], data[headLen:], version)
+	if err != nil {
+		return nil, err
+	}
+
+	//启用了序号，返回带序号的消息
+	if p.seq {
+		return &network.SeqMsg{Seq: seq, Msg: msg}, nil
+	}
+
+	return msg, nil
+}
+
+//解码以消息名字作为消息ID的消息
+func (p *Processor) unmarshalName(data []byte, version uint32) (interface{}, error) {
+	//用于存储解码数据
+	m, err := p.codec.UnmarshalMap(data)
+	if err != nil {
+		return nil, err
+	}
+
+	//带有序号，取出序号
+	rawSeq, hasSeq := m[seqKey]
+	var seq uint32
+	if hasSeq {
+		if err := p.codec.Unmarshal(rawSeq, &seq); err != nil {
+			return nil, fmt.Errorf("invalid %v seq: %v", p.codec.Name(), err)
+		}
+
+		delete(m, seqKey)
+	}
+
+	//去掉序号后只有一个键值对：消息名字和未解码的消息
+	if len(m) != 1 {
+		return nil, fmt.Errorf("invalid %v data", p.codec.Name())
+	}
+
+	for msgName, data := range m {
+		//根据消息名字获取消息ID
+		id, ok := p.msgName[msgName]
+
+		//获取失败
+		if !ok {
+			return nil, fmt.Errorf("message %v not registered", msgName)
+		}
+
+		//按协议版本解码data
+		msg, err := p.decode(p.msgInfo[id], data, version)
+		if err != nil {
+			return nil, err
+		}
+
+		//带有序号，返回带序号的消息
+		if hasSeq {
+			return &network.SeqMsg{Seq: seq, Msg: msg}, nil
+		}
+
+		return msg, nil
+	}
+
+	panic("bug")
+}
+
+//按协议版本解码消息体
+func (p *Processor) decode(i *MsgInfo, data []byte, version uint32) (interface{}, error) {
+	return i.versions.Decode(version, func() interface{} {
+		return reflect.New(i.msgType.Elem()).Interface()
+	}, func(msg interface{}) error {
+		return p.codec.Unmarshal(data, msg)
+	})
+}
+
+//编码消息，消息经过编码中间件后再编码
+func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
+	return p.ApplyMarshal(msg, nil, p.marshal)
+}
+
+//编码
+func (p *Processor) marshal(msg interface{}, _ interface{}) ([][]byte, error) {
+	//带序号的消息，取出序号和消息
+	var seq uint32
+	sm, hasSeq := msg.(*network.SeqMsg)
+	if hasSeq {
+		seq = sm.Seq
+		msg = sm.Msg
+	}
+
+	//获取消息类型
+	msgType := reflect.TypeOf(msg)
+	//获取消息ID
+	_id, ok := p.msgID[msgType]
+
+	//消息未注册
+	if !ok {
+		err := fmt.Errorf("message %s not registered", msgType)
+		return nil, err
+	}
+
+	//以消息名字作为消息ID
+	if p.envelope == EnvelopeName {
+		//创建消息名字映射
+		m := map[string]interface{}{p.msgInfo[_id].msgName: msg}
+
+		//带序号的消息，添加序号
+		if hasSeq {
+			m[seqKey] = seq
+		}
+
+		//编码
+		data, err := p.codec.Marshal(m)
+		return [][]byte{data}, err
+	}
+
+	//带序号的消息，但未启用序号
+	if hasSeq && !p.seq {
+		return nil, fmt.Errorf("%v seq not enabled", p.codec.Name())
+	}
+
+	//消息ID和序号
+	head := make([]byte, 2, 6)
+
+	//根据字节序将_id和seq序列化到head字节切片上
+	if p.littleEndian {
+		binary.LittleEndian.PutUint16(head, _id)
+		if p.seq {
+			head = binary.LittleEndian.AppendUint32(head, seq)
+		}
+	} else {
+		binary.BigEndian.PutUint16(head, _id)
+		if p.seq {
+			head = binary.BigEndian.AppendUint32(head, seq)
+		}
+	}
+
+	//编码
+	data, err := p.codec.Marshal(msg)
+
+	return [][]byte{head, data}, err
+}
+
+//注册消息的旧版本
+//协议版本不大于maxVersion的客户端以msg的消息ID（或名字）发送old，解码后经upgrade转换为msg再路由
+func (p *Processor) RegisterVersion(msg interface{}, maxVersion uint32, old interface{}, upgrade func(old interface{}) interface{}) {
+	//获取消息类型
+	msgType := reflect.TypeOf(msg)
+	oldType := reflect.TypeOf(old)
+	//获取消息ID
+	id, ok := p.msgID[msgType]
+
+	//消息未注册
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+	}
+
+	//旧版本需要是指针
+	if oldType == nil || oldType.Kind() != reflect.Ptr {
+		log.Fatal("%v message pointer required", p.codec.Name())
+	}
+
+	//添加旧版本
+	err := p.msgInfo[id].versions.Add(maxVersion, func() interface{} {
+		return reflect.New(oldType.Elem()).Interface()
+	}, upgrade)
+	if err != nil {
+		log.Fatal("message %s version %v: %v", msgType, maxVersion, err)
+	}
+}
+
+//设置消息已弃用，收到该消息时计数并在第一次收到时输出日志
+func (p *Processor) SetDeprecated(msg interface{}) {
+	//获取消息类型
+	msgType := reflect.TypeOf(msg)
+	//获取消息ID
+	id, ok := p.msgID[msgType]
+
+	//消息未注册
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+	}
+
+	p.msgInfo[id].versions.SetDeprecated()
+}
+
+//弃用消息（包括旧版本）的使用统计
+func (p *Processor) DeprecatedStats() []network.DeprecatedStat {
+	var stats []network.DeprecatedStat
+	for _, i := range p.msgInfo {
+		stats = append(stats, i.versions.Stats(i.msgName)...)
+	}
+
+	return stats
+}
+
+//对所有消息应用函数
+func (p *Processor) Range(f func(id uint16, t reflect.Type)) {
+	for id, i := range p.msgInfo {
+		f(uint16(id), i.msgType)
+	}
+}
@@ -0,0 +1,271 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"squash/network"
+	"strings"
+	"testing"
+)
+
+//测试用编码器，消息体使用json编码（map的键按字节序排序）
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func (jsonCodec) UnmarshalMap(data []byte) (map[string][]byte, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	m := make(map[string][]byte, len(raw))
+	for k, v := range raw {
+		m[k] = v
+	}
+	return m, nil
+}
+
+type Hello struct {
+	Name string
+}
+
+type Bye struct {
+	Code int32
+}
+
+//旧版本的Hello
+type HelloV1 struct {
+	Nick string
+}
+
+//去掉空白后解析十六进制字符串
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func newProcessor(envelope int, littleEndian bool, seq bool) *Processor {
+	p := NewProcessor(jsonCodec{})
+	p.SetEnvelope(envelope)
+	p.SetByteOrder(littleEndian)
+	p.SetSeq(seq)
+	p.Register(&Hello{})
+	p.Register(&Bye{})
+	return p
+}
+
+//信封格式，消息体为json
+var envelopes = []struct {
+	name         string
+	envelope     int
+	littleEndian bool
+	seq          bool
+	msg          interface{}
+	data         string
+}{
+	//id(2字节大端) + 消息体
+	{"id", EnvelopeID, false, false, &Bye{Code: -1}, "0001" + hex.EncodeToString([]byte(`{"Code":-1}`))},
+	//id(2字节小端)
+	{"id little endian", EnvelopeID, true, false, &Bye{Code: -1}, "0100" + hex.EncodeToString([]byte(`{"Code":-1}`))},
+	//id + seq(4字节大端) + 消息体
+	{"id seq", EnvelopeID, false, true, &network.SeqMsg{Seq: 7, Msg: &Hello{Name: "hi"}}, "0000 00000007" + hex.EncodeToString([]byte(`{"Name":"hi"}`))},
+	//id + seq(4字节小端) + 消息体
+	{"id seq little endian", EnvelopeID, true, true, &network.SeqMsg{Seq: 7, Msg: &Hello{Name: "hi"}}, "0000 07000000" + hex.EncodeToString([]byte(`{"Name":"hi"}`))},
+	//{"msgName": 消息}
+	{"name", EnvelopeName, false, false, &Hello{Name: "hi"}, hex.EncodeToString([]byte(`{"Hello":{"Name":"hi"}}`))},
+	//{"msgName": 消息, "seq": seq}，名字信封不需要SetSeq
+	{"name seq", EnvelopeName, false, false, &network.SeqMsg{Seq: 7, Msg: &Hello{Name: "hi"}}, hex.EncodeToString([]byte(`{"Hello":{"Name":"hi"},"seq":7}`))},
+}
+
+func TestMarshal(t *testing.T) {
+	for _, e := range envelopes {
+		p := newProcessor(e.envelope, e.littleEndian, e.seq)
+		data, err := p.Marshal(e.msg)
+		if err != nil {
+			t.Fatalf("%v: %v", e.name, err)
+		}
+		if got, want := bytes.Join(data, nil), unhex(t, e.data); !bytes.Equal(got, want) {
+			t.Errorf("%v: got %x, want %x", e.name, got, want)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	for _, e := range envelopes {
+		p := newProcessor(e.envelope, e.littleEndian, e.seq)
+		msg, err := p.Unmarshal(unhex(t, e.data))
+		if err != nil {
+			t.Fatalf("%v: %v", e.name, err)
+		}
+		if !reflect.DeepEqual(msg, e.msg) {
+			t.Errorf("%v: got %#v, want %#v", e.name, msg, e.msg)
+		}
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	//未注册的消息
+	p := newProcessor(EnvelopeID, false, false)
+	if _, err := p.Marshal(&HelloV1{}); err == nil {
+		t.Error("unregistered message: no error")
+	}
+
+	//ID信封未启用序号
+	if _, err := p.Marshal(&network.SeqMsg{Seq: 1, Msg: &Hello{}}); err == nil {
+		t.Error("seq not enabled: no error")
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		envelope int
+		seq      bool
+		data     []byte
+	}{
+		{"too short", EnvelopeID, false, []byte{0}},
+		{"too short seq", EnvelopeID, true, []byte{0, 0, 0, 0, 7}},
+		{"unknown id", EnvelopeID, false, append([]byte{0, 2}, `{}`...)},
+		{"unknown name", EnvelopeName, false, []byte(`{"Nope":{}}`)},
+		{"two messages", EnvelopeName, false, []byte(`{"Hello":{},"Bye":{}}`)},
+		{"invalid seq", EnvelopeName, false, []byte(`{"Hello":{},"seq":"x"}`)},
+		{"invalid body", EnvelopeID, false, append([]byte{0, 0}, `[`...)},
+	} {
+		p := newProcessor(c.envelope, false, c.seq)
+		if msg, err := p.Unmarshal(c.data); err == nil {
+			t.Errorf("%v: got %#v, want error", c.name, msg)
+		}
+	}
+}
+
+//记录调用的路由器
+type recordRouter struct {
+	id   interface{}
+	args []interface{}
+}
+
+func (r *recordRouter) Go(id interface{}, args ...interface{}) {
+	r.id = id
+	r.args = args
+}
+
+//测试用代理
+type agent struct {
+	authenticated bool
+}
+
+func (a *agent) Authenticated() bool {
+	return a.authenticated
+}
+
+func TestRoute(t *testing.T) {
+	p := newProcessor(EnvelopeID, false, true)
+
+	//处理函数以[msg, userData, seq]调用
+	var args []interface{}
+	p.SetHandler(&Hello{}, func(a []interface{}) {
+		args = a
+	})
+	//路由器以消息类型为id调用
+	r := new(recordRouter)
+	p.SetRouter(&Bye{}, r)
+
+	a := new(agent)
+	hello := &Hello{Name: "hi"}
+	if err := p.Route(&network.SeqMsg{Seq: 7, Msg: hello}, a); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args, []interface{}{hello, a, uint32(7)}) {
+		t.Errorf("handler args %#v", args)
+	}
+
+	bye := &Bye{Code: 1}
+	if err := p.Route(bye, a); err != nil {
+		t.Fatal(err)
+	}
+	if r.id != reflect.TypeOf(bye) || !reflect.DeepEqual(r.args, []interface{}{bye, a, uint32(0)}) {
+		t.Errorf("router id %v args %#v", r.id, r.args)
+	}
+
+	//未注册的消息
+	if err := p.Route(&HelloV1{}, a); err == nil {
+		t.Error("unregistered message: no error")
+	}
+}
+
+func TestRouteAuth(t *testing.T) {
+	p := newProcessor(EnvelopeID, false, false)
+	p.SetAuthRequired(true)
+	p.SetAllowBeforeAuth(&Hello{})
+	n := 0
+	p.SetHandler(&Hello{}, func([]interface{}) { n++ })
+	p.SetHandler(&Bye{}, func([]interface{}) { n++ })
+
+	//认证前只能发送允许的消息
+	a := new(agent)
+	if err := p.Route(&Hello{}, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Route(&Bye{}, a); err != network.ErrNotAuthenticated {
+		t.Fatalf("got %v, want ErrNotAuthenticated", err)
+	}
+
+	a.authenticated = true
+	if err := p.Route(&Bye{}, a); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("handled %v messages, want 2", n)
+	}
+}
+
+func TestBarrier(t *testing.T) {
+	p := newProcessor(EnvelopeID, false, false)
+	p.SetBarrier(&Hello{})
+
+	if !p.IsBarrier(&Hello{}) || !p.IsBarrier(&network.SeqMsg{Msg: &Hello{}}) {
+		t.Error("Hello is not a barrier")
+	}
+	if p.IsBarrier(&Bye{}) {
+		t.Error("Bye is a barrier")
+	}
+}
+
+func TestRegisterVersion(t *testing.T) {
+	p := newProcessor(EnvelopeName, false, false)
+	p.RegisterVersion(&Hello{}, 3, &HelloV1{}, func(old interface{}) interface{} {
+		return &Hello{Name: old.(*HelloV1).Nick}
+	})
+
+	//协议版本不大于3的客户端按旧版本解码
+	data := []byte(`{"Hello":{"Nick":"hi"}}`)
+	msg, err := p.UnmarshalVersion(data, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg, &Hello{Name: "hi"}) {
+		t.Errorf("got %#v", msg)
+	}
+
+	//最新版本
+	msg, err = p.UnmarshalVersion([]byte(`{"Hello":{"Name":"hi"}}`), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg, &Hello{Name: "hi"}) {
+		t.Errorf("got %#v", msg)
+	}
+
+	stats := p.DeprecatedStats()
+	if len(stats) != 1 || stats[0].Name != "Hello" || stats[0].MaxVersion != 3 || stats[0].Count != 1 {
+		t.Errorf("deprecated stats %+v", stats)
+	}
+}
@@ -13,3 +13,13 @@ type Conn interface {
 	Close()                        //关闭连接
 	Destroy()                      //销毁
 }
+
+//可以返回连接统计的连接（TCPConn、WSConn和FragConn都实现了该接口）
+type StatsConn interface {
+	Stats() ConnStats //返回连接统计
+}
+
+//可以指定帧类型发送消息的连接（如ws连接）
+type FrameWriter interface {
+	WriteFrame(frameType int, args ...[]byte) error //使用指定的帧类型发送消息，帧类型为0时使用连接的默认帧类型
+}
@@ -0,0 +1,162 @@
+package network
+
+import (
+	"errors"
+	"sync"
+)
+
+//分片标志
+const (
+	fragLast = 0 //最后一个分片
+	fragMore = 1 //后面还有分片
+)
+
+//可以一次发送多条消息的连接，多条消息作为一个整体进入发送缓冲区
+type batchWriter interface {
+	writeMsgs(frameType int, msgs [][]byte) error
+}
+
+//分片连接，在底层连接之上透明地收发超过单条消息最大长度的消息
+//发送时将消息拆分成多个分片，读取时再重组，双方都需要使用分片连接
+// -----------------
+// | flag | data   |
+// -----------------
+//flag为1表示后面还有分片，为0表示最后一个分片
+type FragConn struct {
+	Conn                   //底层连接
+	mutexWrite  sync.Mutex //发送互斥锁，底层连接不支持一次发送多条消息时，保证一条消息的分片连续发送
+	maxFragLen  uint32     //每个分片的最大数据长度
+	maxTotalLen uint32     //重组后的最大消息长度
+}
+
+//创建分片连接
+//maxMsgLen为底层连接单条消息的最大长度，maxTotalLen为重组后的最大消息长度
+func NewFragConn(conn Conn, maxMsgLen uint32, maxTotalLen uint32) *FragConn {
+	//单条消息至少要能容纳分片标志和1字节数据
+	if maxMsgLen < 2 {
+		panic("maxMsgLen too small for fragmentation")
+	}
+
+	c := new(FragConn)
+	c.Conn = conn
+	c.maxFragLen = maxMsgLen - 1
+	c.maxTotalLen = maxTotalLen
+	return c
+}
+
+//读取消息，重组所有分片后返回
+func (c *FragConn) ReadMsg() ([]byte, error) {
+	var msg []byte
+
+	for {
+		//读取一个分片
+		b, err := c.Conn.ReadMsg()
+		if err != nil {
+			return nil, err
+		}
+
+		//分片至少包含分片标志
+		if len(b) < 1 {
+			return nil, errors.New("fragment too short")
+		}
+
+		//检查重组后的长度
+		if uint64(len(msg))+uint64(len(b)-1) > uint64(c.maxTotalLen) {
+			return nil, errors.New("message too long")
+		}
+
+		switch b[0] {
+		case fragLast:
+			//只有一个分片，不需要复制
+			if msg == nil {
+				return b[1:], nil
+			}
+
+			return append(msg, b[1:]...), nil
+		case fragMore:
+			msg = append(msg, b[1:]...)
+		default:
+			return nil, errors.New("invalid fragment flag")
+		}
+	}
+}
+
+//发送消息，使用底层连接的默认帧类型
+func (c *FragConn) WriteMsg(args ...[]byte) error {
+	return c.WriteFrame(0, args...)
+}
+
+//使用指定的帧类型发送消息（底层连接不支持指定帧类型时忽略帧类型）
+func (c *FragConn) WriteFrame(frameType int, args ...[]byte) error {
+	var msgLen uint32
+
+	//计算消息长度
+	for i := 0; i < len(args); i++ {
+		msgLen += uint32(len(args[i]))
+	}
+
+	//检查长度是否合法
+	if msgLen > c.maxTotalLen {
+		return errors.New("message too long")
+	}
+
+	//合并消息
+	msg := make([]byte, 0, msgLen)
+	for i := 0; i < len(args); i++ {
+		msg = append(msg, args[i]...)
+	}
+
+	//拆分成多个分片，每个分片以分片标志开头
+	var frags [][]byte
+	for {
+		n := uint32(len(msg))
+		flag := byte(fragLast)
+		if n > c.maxFragLen {
+			n = c.maxFragLen
+			flag = fragMore
+		}
+
+		frag := make([]byte, n+1)
+		frag[0] = flag
+		copy(frag[1:], msg[:n])
+		frags = append(frags, frag)
+		msg = msg[n:]
+
+		if flag == fragLast {
+			break
+		}
+	}
+
+	//底层连接支持一次发送多条消息，所有分片作为一个整体进入发送缓冲区
+	if bw, ok := c.Conn.(batchWriter); ok {
+		return bw.writeMsgs(frameType, frags)
+	}
+
+	//逐个发送分片，加锁保证分片连续
+	c.mutexWrite.Lock()
+	defer c.mutexWrite.Unlock()
+
+	for _, frag := range frags {
+		var err error
+		if fw, ok := c.Conn.(FrameWriter); ok {
+			err = fw.WriteFrame(frameType, frag)
+		} else {
+			err = c.Conn.WriteMsg(frag)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//返回底层连接的统计，底层连接不支持统计时返回零值
+func (c *FragConn) Stats() ConnStats {
+	if sc, ok := c.Conn.(StatsConn); ok {
+		return sc.Stats()
+	}
+
+	return ConnStats{}
+}
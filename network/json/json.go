@@ -5,20 +5,33 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"squash/chanrpc"
 	"squash/log"
+	"squash/network"
 )
 
+//序号使用的键，带序号的消息格式为{"seq": 1, "msgID": {...}}
+const seqKey = "seq"
+
 //处理器
 type Processor struct {
-	msgInfo map[string]*MsgInfo //消息信息映射
+	network.MiddlewareChain //中间件链
+
+	authRequired bool                //是否需要认证
+	msgInfo      map[string]*MsgInfo //消息信息映射
+	frameType    int                 //发送消息使用的ws帧类型，为0时使用连接的默认帧类型
 }
 
 //消息信息
 type MsgInfo struct {
-	msgType    reflect.Type    //消息类型
-	msgRouter  *chanrpc.Server //处理消息的rpc服务器
-	msgHandler MsgHandler      //消息处理函数
+	msgType    reflect.Type   //消息类型
+	msgRouter  chanrpc.Router //处理消息的路由器（rpc服务器或分片路由器）
+	msgHandler MsgHandler     //消息处理函数
+
+	allowBeforeAuth bool                //是否允许在认证前发送
+	barrier         bool                //是否为屏障消息
+	versions        network.MsgVersions //旧版本和弃用状态
 }
 
 //消息处理函数
@@ -52,6 +65,11 @@ func (p *Processor) Register(msg interface{}) {
 		log.Fatal("unnamed json message")
 	}
 
+	//消息ID不能与序号的键相同
+	if msgID == seqKey {
+		log.Fatal("json message name %v is reserved", seqKey)
+	}
+
 	//消息已注册
 	if _, ok := p.msgInfo[msgID]; ok {
 		log.Fatal("message %v is already registered", msgID)
@@ -66,7 +84,7 @@ func (p *Processor) Register(msg interface{}) {
 }
 
 //设置路由
-func (p *Processor) SetRouter(msg interface{}, msgRouter *chanrpc.Server) {
+func (p *Processor) SetRouter(msg interface{}, msgRouter chanrpc.Router) {
 	//获取消息类型
 	msgType := reflect.TypeOf(msg)
 
@@ -85,7 +103,12 @@ func (p *Processor) SetRouter(msg interface{}, msgRouter *chanrpc.Server) {
 		log.Fatal("message %v not registered", msgID)
 	}
 
-	//保存rpc服务器引用
+	//路由器不能为空
+	if chanrpc.IsNilRouter(msgRouter) {
+		log.Fatal("message %v router is nil", msgID)
+	}
+
+	//保存路由器引用
 	i.msgRouter = msgRouter
 }
 
@@ -113,8 +136,90 @@ func (p *Processor) SetHandler(msg interface{}, msgHandler MsgHandler) {
 	i.msgHandler = msgHandler
 }
 
-//路由
+//设置是否需要认证
+//需要认证时，未认证的代理（network.Authenticator）只能发送允许在认证前发送的消息，其他消息路由时返回network.ErrNotAuthenticated
+func (p *Processor) SetAuthRequired(required bool) {
+	p.authRequired = required
+}
+
+//设置消息允许在认证前发送（如登录消息）
+func (p *Processor) SetAllowBeforeAuth(msg interface{}) {
+	//获取消息类型
+	msgType := reflect.TypeOf(msg)
+
+	//判断消息的合法性（不能为空，需要是指针）
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.Fatal("json message pointer required")
+	}
+
+	//获取消息本身（不是指针）的名字，作为消息ID
+	msgID := msgType.Elem().Name()
+	//根据消息ID获取消息信息
+	i, ok := p.msgInfo[msgID]
+
+	//获取消息信息失败
+	if !ok {
+		log.Fatal("message %v not registered", msgID)
+	}
+
+	//允许在认证前发送
+	i.allowBeforeAuth = true
+}
+
+//设置屏障消息（如登录、握手消息），gate的代理路由该消息后暂停读取，直到处理函数设置认证状态或协议版本（参见network.BarrierProcessor）
+func (p *Processor) SetBarrier(msg interface{}) {
+	//获取消息类型
+	msgType := reflect.TypeOf(msg)
+
+	//判断消息的合法性（不能为空，需要是指针）
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.Fatal("json message pointer required")
+	}
+
+	//获取消息本身（不是指针）的名字，作为消息ID
+	msgID := msgType.Elem().Name()
+	//根据消息ID获取消息信息
+	i, ok := p.msgInfo[msgID]
+
+	//获取消息信息失败
+	if !ok {
+		log.Fatal("message %v not registered", msgID)
+	}
+
+	//屏障消息
+	i.barrier = true
+}
+
+//实现network.BarrierProcessor接口的IsBarrier方法
+func (p *Processor) IsBarrier(msg interface{}) bool {
+	//带序号的消息，取出消息
+	if sm, ok := msg.(*network.SeqMsg); ok {
+		msg = sm.Msg
+	}
+
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		return false
+	}
+
+	i, ok := p.msgInfo[msgType.Elem().Name()]
+	return ok && i.barrier
+}
+
+//路由，消息经过路由中间件后再分发
 func (p *Processor) Route(msg interface{}, userData interface{}) error {
+	return p.ApplyRoute(msg, userData, p.route)
+}
+
+//分发消息
+func (p *Processor) route(msg interface{}, userData interface{}) error {
+	//带序号的消息，取出序号和消息
+	var seq uint32
+	if sm, ok := msg.(*network.SeqMsg); ok {
+		seq = sm.Seq
+		msg = sm.Msg
+	}
+
 	//获取消息类型
 	msgType := reflect.TypeOf(msg)
 
@@ -133,21 +238,43 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 		return fmt.Errorf("message %v not registered", msgID)
 	}
 
+	//需要认证并且该消息不允许在认证前发送，检查代理是否已认证
+	if p.authRequired && !i.allowBeforeAuth {
+		if a, ok := userData.(network.Authenticator); ok && !a.Authenticated() {
+			return network.ErrNotAuthenticated
+		}
+	}
+
 	//调用消息处理函数
 	if i.msgHandler != nil {
-		i.msgHandler([]interface{}{msg, userData})
+		i.msgHandler([]interface{}{msg, userData, seq})
 	}
 
 	//rpc服务器自己发起调用
 	if i.msgRouter != nil {
-		i.msgRouter.Go(msgType, msg, userData)
+		i.msgRouter.Go(msgType, msg, userData, seq)
 	}
 
 	return nil
 }
 
-//解码消息
+//设置发送消息使用的ws帧类型（network.TextFrame或network.BinaryFrame）
+func (p *Processor) SetFrameType(frameType int) {
+	p.frameType = frameType
+}
+
+//实现network.FrameTyper接口，返回发送消息使用的ws帧类型
+func (p *Processor) FrameType(msg interface{}) int {
+	return p.frameType
+}
+
+//解码消息（最新版本）
 func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
+	return p.UnmarshalVersion(data, 0)
+}
+
+//按客户端的协议版本解码消息，旧版本消息转换为最新版本后返回，version为0时使用最新版本
+func (p *Processor) UnmarshalVersion(data []byte, version uint32) (interface{}, error) {
 	//用于存储解码数据
 	var m map[string]json.RawMessage
 	//解码
@@ -158,7 +285,18 @@ func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 		return nil, err
 	}
 
-	//m的长度必为1，也就是只有一个键值对：msgID和未解码的data（data是原生json对象）
+	//带有序号，取出序号
+	rawSeq, hasSeq := m[seqKey]
+	var seq uint32
+	if hasSeq {
+		if err := json.Unmarshal(rawSeq, &seq); err != nil {
+			return nil, fmt.Errorf("invalid json seq: %v", err)
+		}
+
+		delete(m, seqKey)
+	}
+
+	//去掉序号后m的长度必为1，也就是只有一个键值对：msgID和未解码的data（data是原生json对象）
 	if len(m) != 1 {
 		return nil, errors.New("invalid json data")
 	}
@@ -173,18 +311,40 @@ func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 			return nil, fmt.Errorf("message %v not registered", msgID)
 		}
 
-		//用于存储解码数据
-		msg := reflect.New(i.msgType.Elem()).Interface()
+		//按协议版本解码data
+		msg, err := i.versions.Decode(version, func() interface{} {
+			return reflect.New(i.msgType.Elem()).Interface()
+		}, func(msg interface{}) error {
+			return json.Unmarshal(data, msg)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		//带有序号，返回带序号的消息
+		if hasSeq {
+			return &network.SeqMsg{Seq: seq, Msg: msg}, nil
+		}
 
-		//解码data
-		return msg, json.Unmarshal(data, msg)
+		return msg, nil
 	}
 
 	panic("bug")
 }
 
-//编码消息
-func (p *Processor) Marshal(msg interface{}) ([]byte, error) {
+//编码消息，消息经过编码中间件后再编码
+func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
+	return p.ApplyMarshal(msg, nil, p.marshal)
+}
+
+//编码
+func (p *Processor) marshal(msg interface{}, _ interface{}) ([][]byte, error) {
+	//带序号的消息，取出序号和消息
+	sm, hasSeq := msg.(*network.SeqMsg)
+	if hasSeq {
+		msg = sm.Msg
+	}
+
 	//获取消息类型
 	msgType := reflect.TypeOf(msg)
 
@@ -204,6 +364,97 @@ func (p *Processor) Marshal(msg interface{}) ([]byte, error) {
 	//创建消息ID映射
 	m := map[string]interface{}{msgID: msg}
 
+	//带序号的消息，添加序号
+	if hasSeq {
+		m[seqKey] = sm.Seq
+	}
+
 	//编码
-	return json.Marshal(m)
+	data, err := json.Marshal(m)
+
+	return [][]byte{data}, err
+}
+
+//注册消息的旧版本
+//协议版本不大于maxVersion的客户端以msg的消息ID发送old，解码后经upgrade转换为msg再路由
+func (p *Processor) RegisterVersion(msg interface{}, maxVersion uint32, old interface{}, upgrade func(old interface{}) interface{}) {
+	//获取消息类型
+	msgType := reflect.TypeOf(msg)
+	oldType := reflect.TypeOf(old)
+
+	//判断消息的合法性（不能为空，需要是指针）
+	if msgType == nil || msgType.Kind() != reflect.Ptr || oldType == nil || oldType.Kind() != reflect.Ptr {
+		log.Fatal("json message pointer required")
+	}
+
+	//获取消息本身（不是指针）的名字，作为消息ID
+	msgID := msgType.Elem().Name()
+	//根据消息ID获取消息信息
+	i, ok := p.msgInfo[msgID]
+
+	//获取消息信息失败
+	if !ok {
+		log.Fatal("message %v not registered", msgID)
+	}
+
+	//添加旧版本
+	err := i.versions.Add(maxVersion, func() interface{} {
+		return reflect.New(oldType.Elem()).Interface()
+	}, upgrade)
+	if err != nil {
+		log.Fatal("message %v version %v: %v", msgID, maxVersion, err)
+	}
+}
+
+//设置消息已弃用，收到该消息时计数并在第一次收到时输出日志
+func (p *Processor) SetDeprecated(msg interface{}) {
+	//获取消息类型
+	msgType := reflect.TypeOf(msg)
+
+	//判断消息的合法性（不能为空，需要是指针）
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.Fatal("json message pointer required")
+	}
+
+	//获取消息本身（不是指针）的名字，作为消息ID
+	msgID := msgType.Elem().Name()
+	//根据消息ID获取消息信息
+	i, ok := p.msgInfo[msgID]
+
+	//获取消息信息失败
+	if !ok {
+		log.Fatal("message %v not registered", msgID)
+	}
+
+	i.versions.SetDeprecated()
+}
+
+//弃用消息（包括旧版本）的使用统计
+func (p *Processor) DeprecatedStats() []network.DeprecatedStat {
+	var stats []network.DeprecatedStat
+	p.Range(func(id string, _ reflect.Type) {
+		stats = append(stats, p.msgInfo[id].versions.Stats(id)...)
+	})
+
+	return stats
+}
+
+//按消息ID顺序对所有消息应用函数
+func (p *Processor) Range(f func(id string, t reflect.Type)) {
+	//消息ID排序，保证顺序稳定
+	ids := make([]string, 0, len(p.msgInfo))
+	for id := range p.msgInfo {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		f(id, p.msgInfo[id].msgType)
+	}
+}
+
+//消息是否设置了处理函数或路由（即由服务器处理的消息）
+func (p *Processor) Handled(id string) bool {
+	i, ok := p.msgInfo[id]
+	return ok && (i.msgHandler != nil || i.msgRouter != nil)
 }
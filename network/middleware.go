@@ -0,0 +1,51 @@
+package network
+
+//路由函数
+type RouteFunc func(msg interface{}, userData interface{}) error
+
+//编码函数
+type MarshalFunc func(msg interface{}, userData interface{}) ([][]byte, error)
+
+//路由中间件，包装下一个路由函数
+//中间件可以检查、修改、计时消息，返回错误表示拒绝消息，不调用next表示丢弃消息
+type RouteMiddleware func(next RouteFunc) RouteFunc
+
+//编码中间件，包装下一个编码函数
+//中间件可以检查、修改、计时消息，返回错误表示拒绝发送，不调用next并返回空数据表示丢弃消息
+type MarshalMiddleware func(next MarshalFunc) MarshalFunc
+
+//中间件链
+type MiddlewareChain struct {
+	routeMiddlewares   []RouteMiddleware   //路由中间件
+	marshalMiddlewares []MarshalMiddleware //编码中间件
+}
+
+//添加路由中间件，先添加的中间件先执行
+func (c *MiddlewareChain) UseRoute(mw ...RouteMiddleware) {
+	c.routeMiddlewares = append(c.routeMiddlewares, mw...)
+}
+
+//添加编码中间件，先添加的中间件先执行
+func (c *MiddlewareChain) UseMarshal(mw ...MarshalMiddleware) {
+	c.marshalMiddlewares = append(c.marshalMiddlewares, mw...)
+}
+
+//消息依次经过所有路由中间件后交给f
+func (c *MiddlewareChain) ApplyRoute(msg interface{}, userData interface{}, f RouteFunc) error {
+	//从后往前包装，使先添加的中间件在最外层
+	for i := len(c.routeMiddlewares) - 1; i >= 0; i-- {
+		f = c.routeMiddlewares[i](f)
+	}
+
+	return f(msg, userData)
+}
+
+//消息依次经过所有编码中间件后交给f
+func (c *MiddlewareChain) ApplyMarshal(msg interface{}, userData interface{}, f MarshalFunc) ([][]byte, error) {
+	//从后往前包装，使先添加的中间件在最外层
+	for i := len(c.marshalMiddlewares) - 1; i >= 0; i-- {
+		f = c.marshalMiddlewares[i](f)
+	}
+
+	return f(msg, userData)
+}
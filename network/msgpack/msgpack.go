@@ -0,0 +1,75 @@
+package msgpack
+
+import (
+	"bytes"
+	"github.com/vmihailenco/msgpack/v5"
+	"squash/network/codec"
+)
+
+//消息信封格式
+const (
+	EnvelopeID   = codec.EnvelopeID   //以注册顺序作为消息ID（默认）
+	EnvelopeName = codec.EnvelopeName //以消息名字作为消息ID
+)
+
+//处理器（消息体使用msgpack编码）
+//EnvelopeID格式：
+// ------------------------
+// | id | msgpack message |
+// ------------------------
+//启用序号后：
+// ------------------------------
+// | id | seq | msgpack message |
+// ------------------------------
+//EnvelopeName格式为msgpack map：{"msgName": message}，启用序号后为{"seq": seq, "msgName": message}
+type Processor struct {
+	*codec.Processor
+}
+
+//消息信息
+type MsgInfo = codec.MsgInfo
+
+//消息处理函数
+type MsgHandler = codec.MsgHandler
+
+//创建一个处理器
+func NewProcessor() *Processor {
+	return &Processor{codec.NewProcessor(msgpackCodec{})}
+}
+
+//msgpack编码器
+type msgpackCodec struct{}
+
+//实现codec.Codec接口的Name方法
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+//实现codec.Codec接口的Marshal方法，map按键排序以保证结果确定
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetSortMapKeys(true)
+	err := enc.Encode(v)
+	return buf.Bytes(), err
+}
+
+//实现codec.Codec接口的Unmarshal方法
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+//实现codec.Codec接口的UnmarshalMap方法
+func (msgpackCodec) UnmarshalMap(data []byte) (map[string][]byte, error) {
+	var m map[string]msgpack.RawMessage
+	if err := msgpack.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string][]byte, len(m))
+	for k, v := range m {
+		raw[k] = v
+	}
+
+	return raw, nil
+}
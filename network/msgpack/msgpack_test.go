@@ -0,0 +1,64 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"squash/network"
+	"strings"
+	"testing"
+)
+
+type Hello struct {
+	Name string
+}
+
+type Bye struct {
+	Code int32
+}
+
+//参考编码（msgpack规范），信封格式的行为由network/codec测试
+func TestVectors(t *testing.T) {
+	for _, v := range []struct {
+		name       string
+		msg        interface{}
+		data       string
+		decodeOnly bool //其他实现的编码，只测试解码
+	}{
+		//id(2字节) + fixmap(1){fixstr "Code": int32 -1}
+		{"int32", &Bye{Code: -1}, "0001 81 a4436f6465 d2ffffffff", false},
+		//fixmap(2){"Hello": fixmap(1){"Name": fixstr "hi"}, "seq": uint32 7}，键按字节序排序
+		{"name seq", &network.SeqMsg{Seq: 7, Msg: &Hello{Name: "hi"}}, "82 a548656c6c6f 81 a44e616d65 a26869 a3736571 ce00000007", false},
+		//整数使用最短格式，键的顺序不同：fixmap(2){"seq": fixint 7, "Bye": {"Code": negative fixint -1}}
+		{"compact", &network.SeqMsg{Seq: 7, Msg: &Bye{Code: -1}}, "82 a3736571 07 a3427965 81 a4436f6465 ff", true},
+	} {
+		p := NewProcessor()
+		if _, ok := v.msg.(*network.SeqMsg); ok {
+			p.SetEnvelope(EnvelopeName)
+		}
+		p.Register(&Hello{})
+		p.Register(&Bye{})
+
+		want, err := hex.DecodeString(strings.Join(strings.Fields(v.data), ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !v.decodeOnly {
+			data, err := p.Marshal(v.msg)
+			if err != nil {
+				t.Fatalf("%v: %v", v.name, err)
+			}
+			if got := bytes.Join(data, nil); !bytes.Equal(got, want) {
+				t.Errorf("%v: marshal got %x, want %x", v.name, got, want)
+			}
+		}
+
+		msg, err := p.Unmarshal(want)
+		if err != nil {
+			t.Fatalf("%v: %v", v.name, err)
+		}
+		if !reflect.DeepEqual(msg, v.msg) {
+			t.Errorf("%v: unmarshal got %#v, want %#v", v.name, msg, v.msg)
+		}
+	}
+}
@@ -6,3 +6,8 @@ type Processor interface {
 	Unmarshal(data []byte) (interface{}, error)        //解码
 	Marshal(msg interface{}) ([][]byte, error)         //编码
 }
+
+//帧类型选择接口（可选），处理器实现该接口后可以为每条消息选择ws帧类型
+type FrameTyper interface {
+	FrameType(msg interface{}) int //返回TextFrame或BinaryFrame，返回0时使用连接的默认帧类型
+}
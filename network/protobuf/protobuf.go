@@ -4,28 +4,49 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
 	"math"
 	"reflect"
 	"squash/chanrpc"
 	"squash/log"
+	"squash/network"
 )
 
-//处理器
+//处理器（基于google.golang.org/protobuf）
 // -------------------------
 // | id | protobuf message |
 // -------------------------
+//启用序号后：
+// -------------------------------
+// | id | seq | protobuf message |
+// -------------------------------
 type Processor struct {
-	littleEndian bool                    //是否小端
-	msgInfo      []*MsgInfo              //消息信息切片
-	msgID        map[reflect.Type]uint16 //消息ID映射
+	network.MiddlewareChain //中间件链
+
+	authRequired bool                              //是否需要认证
+	littleEndian bool                              //是否小端
+	seq          bool                              //是否启用序号
+	resolver     protoregistry.MessageTypeResolver //按全名查找消息类型的解析器
+	msgInfo      []*MsgInfo                        //消息信息切片
+	msgID        map[protoreflect.FullName]uint16  //消息ID映射（以消息全名为键）
 }
 
 //消息信息
 type MsgInfo struct {
-	msgType    reflect.Type    //消息类型
-	msgRouter  *chanrpc.Server //处理消息的rpc服务器
-	msgHandler MsgHandler      //消息处理函数
+	msgType     reflect.Type             //消息类型
+	protoType   protoreflect.MessageType //protobuf消息类型
+	msgRouter   chanrpc.Router           //处理消息的路由器（rpc服务器或分片路由器）
+	msgHandler  MsgHandler               //消息处理函数
+	routeByName bool                     //路由时以消息全名为id（动态消息），否则以消息类型为id
+
+	allowBeforeAuth bool                //是否允许在认证前发送
+	barrier         bool                //是否为屏障消息
+	versions        network.MsgVersions //旧版本和弃用状态
 }
 
 //消息处理函数
@@ -37,8 +58,10 @@ func NewProcessor() *Processor {
 	p := new(Processor)
 	//字节序默认大端
 	p.littleEndian = false
+	//默认使用全局注册表解析消息类型
+	p.resolver = protoregistry.GlobalTypes
 	//创建消息ID映射
-	p.msgID = make(map[reflect.Type]uint16)
+	p.msgID = make(map[protoreflect.FullName]uint16)
 
 	return p
 }
@@ -48,19 +71,91 @@ func (p *Processor) SetByteOrder(littleEndian bool) {
 	p.littleEndian = littleEndian
 }
 
+//设置是否启用序号（请求/响应关联），启用后每条消息在ID之后带有4字节序号
+func (p *Processor) SetSeq(seq bool) {
+	p.seq = seq
+}
+
+//设置按全名查找消息类型的解析器，默认为protoregistry.GlobalTypes
+func (p *Processor) SetResolver(resolver protoregistry.MessageTypeResolver) {
+	p.resolver = resolver
+}
+
+//设置描述符集合（如protoc --descriptor_set_out生成），集合中的消息使用dynamicpb动态解码
+//设置后RegisterByName从描述符集合中查找消息类型，适用于没有生成代码的工具（如抓包分析）
+func (p *Processor) SetDescriptorSet(fds *descriptorpb.FileDescriptorSet) error {
+	//解析描述符集合
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return err
+	}
+
+	//为集合中的所有消息创建动态类型
+	types := new(protoregistry.Types)
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		err = registerDynamic(types, fd.Messages())
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	p.resolver = types
+
+	return nil
+}
+
+//递归注册消息及其嵌套消息的动态类型
+func registerDynamic(types *protoregistry.Types, mds protoreflect.MessageDescriptors) error {
+	for i := 0; i < mds.Len(); i++ {
+		md := mds.Get(i)
+
+		//跳过map字段自动生成的条目消息
+		if md.IsMapEntry() {
+			continue
+		}
+
+		if err := types.RegisterMessage(dynamicpb.NewMessageType(md)); err != nil {
+			return err
+		}
+
+		if err := registerDynamic(types, md.Messages()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 //注册消息
 func (p *Processor) Register(msg proto.Message) {
-	//获取消息类型
-	msgType := reflect.TypeOf(msg)
+	//判断消息的合法性（不能为空）
+	if msg == nil {
+		log.Fatal("protobuf message required")
+	}
+
+	p.register(msg.ProtoReflect().Type())
+}
 
-	//判断消息的合法性（不能为空，需要是指针）
-	if msgType == nil || msgType.Kind() != reflect.Ptr {
-		log.Fatal("protobuf message pointer required")
+//根据消息全名注册消息，消息类型由解析器查找
+func (p *Processor) RegisterByName(name protoreflect.FullName) {
+	//查找消息类型
+	mt, err := p.resolver.FindMessageByName(name)
+	if err != nil {
+		log.Fatal("message %v not found: %v", name, err)
 	}
 
+	p.register(mt)
+}
+
+//注册消息类型
+func (p *Processor) register(mt protoreflect.MessageType) {
+	//获取消息全名
+	name := mt.Descriptor().FullName()
+
 	//消息已注册
-	if _, ok := p.msgID[msgType]; ok {
-		log.Fatal("message %s is already registered", msgType)
+	if _, ok := p.msgID[name]; ok {
+		log.Fatal("message %v is already registered", name)
 	}
 
 	//消息切片已满
@@ -71,87 +166,214 @@ func (p *Processor) Register(msg proto.Message) {
 	//新建一个消息信息
 	i := new(MsgInfo)
 	//保存消息类型
-	i.msgType = msgType
+	i.msgType = reflect.TypeOf(mt.Zero().Interface())
+	i.protoType = mt
 	//保存消息信息到切片中
 	p.msgInfo = append(p.msgInfo, i)
 	//保存消息ID到映射中
-	p.msgID[msgType] = uint16(len(p.msgInfo) - 1)
+	p.msgID[name] = uint16(len(p.msgInfo) - 1)
+}
+
+//动态消息的类型
+var dynamicType = reflect.TypeOf((*dynamicpb.Message)(nil))
+
+//获取消息全名
+func msgName(msg interface{}) protoreflect.FullName {
+	if m, ok := msg.(proto.Message); ok && m != nil {
+		return m.ProtoReflect().Descriptor().FullName()
+	}
+
+	return ""
 }
 
-//设置路由
-func (p *Processor) SetRouter(msg proto.Message, msgRouter *chanrpc.Server) {
-	//获取消息类型
-	msgType := reflect.TypeOf(msg)
+//设置路由，路由器以消息类型（reflect.Type）为id调用，rpc服务器需要以消息类型注册处理函数
+//动态消息（*dynamicpb.Message）的类型都相同，需要使用SetRouterByName
+func (p *Processor) SetRouter(msg proto.Message, msgRouter chanrpc.Router) {
+	p.setRouter(msg, msgRouter, false)
+}
+
+//设置路由，路由器以消息全名（protoreflect.FullName）为id调用，rpc服务器需要以消息全名注册处理函数
+//用于动态消息（SetDescriptorSet、RegisterByName注册的消息）
+func (p *Processor) SetRouterByName(msg proto.Message, msgRouter chanrpc.Router) {
+	p.setRouter(msg, msgRouter, true)
+}
+
+//设置路由，byName为true时以消息全名为id路由
+func (p *Processor) setRouter(msg proto.Message, msgRouter chanrpc.Router, byName bool) {
+	//获取消息全名
+	name := msgName(msg)
 	//获取消息ID
-	id, ok := p.msgID[msgType]
+	id, ok := p.msgID[name]
 
 	//消息未注册
 	if !ok {
-		log.Fatal("message %s not registered", msgType)
+		log.Fatal("message %v not registered", name)
+	}
+
+	//路由器不能为空
+	if chanrpc.IsNilRouter(msgRouter) {
+		log.Fatal("message %v router is nil", name)
 	}
 
-	//保存rpc服务器引用
-	p.msgInfo[id].msgRouter = msgRouter
+	//动态消息以类型为id时不同消息的id相同
+	i := p.msgInfo[id]
+	if !byName && i.msgType == dynamicType {
+		log.Fatal("message %v is a dynamic message, use SetRouterByName", name)
+	}
+
+	//保存路由器引用
+	i.msgRouter = msgRouter
+	i.routeByName = byName
 }
 
 //设置消息处理函数
 func (p *Processor) SetHandler(msg proto.Message, msgHandler MsgHandler) {
-	//获取消息的类型
-	msgType := reflect.TypeOf(msg)
+	//获取消息全名
+	name := msgName(msg)
 	//获取消息ID
-	id, ok := p.msgID[msgType]
+	id, ok := p.msgID[name]
 
 	//消息未注册
 	if !ok {
-		log.Fatal("message %s not registered", msgType)
+		log.Fatal("message %v not registered", name)
 	}
 
 	//保存消息处理函数
 	p.msgInfo[id].msgHandler = msgHandler
 }
 
-//路由
+//设置是否需要认证
+//需要认证时，未认证的代理（network.Authenticator）只能发送允许在认证前发送的消息，其他消息路由时返回network.ErrNotAuthenticated
+func (p *Processor) SetAuthRequired(required bool) {
+	p.authRequired = required
+}
+
+//设置消息允许在认证前发送（如登录消息）
+func (p *Processor) SetAllowBeforeAuth(msg proto.Message) {
+	//获取消息全名
+	name := msgName(msg)
+	//获取消息ID
+	id, ok := p.msgID[name]
+
+	//消息未注册
+	if !ok {
+		log.Fatal("message %v not registered", name)
+	}
+
+	//允许在认证前发送
+	p.msgInfo[id].allowBeforeAuth = true
+}
+
+//设置屏障消息（如登录、握手消息），gate的代理路由该消息后暂停读取，直到处理函数设置认证状态或协议版本（参见network.BarrierProcessor）
+func (p *Processor) SetBarrier(msg proto.Message) {
+	//获取消息全名
+	name := msgName(msg)
+	//获取消息ID
+	id, ok := p.msgID[name]
+
+	//消息未注册
+	if !ok {
+		log.Fatal("message %v not registered", name)
+	}
+
+	//屏障消息
+	p.msgInfo[id].barrier = true
+}
+
+//实现network.BarrierProcessor接口的IsBarrier方法
+func (p *Processor) IsBarrier(msg interface{}) bool {
+	//带序号的消息，取出消息
+	if sm, ok := msg.(*network.SeqMsg); ok {
+		msg = sm.Msg
+	}
+
+	id, ok := p.msgID[msgName(msg)]
+	return ok && p.msgInfo[id].barrier
+}
+
+//路由，消息经过路由中间件后再分发
 func (p *Processor) Route(msg interface{}, userData interface{}) error {
-	//获取消息类型
-	msgType := reflect.TypeOf(msg)
+	return p.ApplyRoute(msg, userData, p.route)
+}
+
+//分发消息
+func (p *Processor) route(msg interface{}, userData interface{}) error {
+	//带序号的消息，取出序号和消息
+	var seq uint32
+	if sm, ok := msg.(*network.SeqMsg); ok {
+		seq = sm.Seq
+		msg = sm.Msg
+	}
+
+	//获取消息全名
+	name := msgName(msg)
 	//获取消息ID
-	id, ok := p.msgID[msgType]
+	id, ok := p.msgID[name]
 
 	//判断消息是否已经注册
 	if !ok {
-		return fmt.Errorf("message %s not registered", msgType)
+		return fmt.Errorf("message %s not registered", reflect.TypeOf(msg))
 	}
 
 	//消息未注册
 	i := p.msgInfo[id]
 
+	//需要认证并且该消息不允许在认证前发送，检查代理是否已认证
+	if p.authRequired && !i.allowBeforeAuth {
+		if a, ok := userData.(network.Authenticator); ok && !a.Authenticated() {
+			return network.ErrNotAuthenticated
+		}
+	}
+
 	//调用消息处理函数
 	if i.msgHandler != nil {
-		i.msgHandler([]interface{}{msg, userData})
+		i.msgHandler([]interface{}{msg, userData, seq})
 	}
 
 	//rpc服务器自己发起调用
 	if i.msgRouter != nil {
-		i.msgRouter.Go(msgType, msg, userData)
+		if i.routeByName {
+			i.msgRouter.Go(name, msg, userData, seq)
+		} else {
+			i.msgRouter.Go(i.msgType, msg, userData, seq)
+		}
 	}
 
 	return nil
 }
 
-//解码消息
+//解码消息（最新版本）
 func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
-	//消息过短（[][]byte{id, data}为2字节）
-	if len(data) < 2 {
+	return p.UnmarshalVersion(data, 0)
+}
+
+//按客户端的协议版本解码消息，旧版本消息转换为最新版本后返回，version为0时使用最新版本
+func (p *Processor) UnmarshalVersion(data []byte, version uint32) (interface{}, error) {
+	//消息ID和序号所占的字节数
+	headLen := 2
+	if p.seq {
+		headLen = 6
+	}
+
+	//消息过短（[][]byte{id, data}为2字节，启用序号后为6字节）
+	if len(data) < headLen {
 		return nil, errors.New("protobuf data too short")
 	}
 
 	var id uint16
+	var seq uint32
 
-	//获取消息ID
+	//获取消息ID和序号
 	if p.littleEndian {
 		id = binary.LittleEndian.Uint16(data)
+		if p.seq {
+			seq = binary.LittleEndian.Uint32(data[2:])
+		}
 	} else {
 		id = binary.BigEndian.Uint16(data)
+		if p.seq {
+			seq = binary.BigEndian.Uint32(data[2:])
+		}
 	}
 
 	//ID超出消息切片长度
@@ -159,23 +381,51 @@ func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 		return nil, fmt.Errorf("message id %v not registered", id)
 	}
 
-	//用于存储解码数据
-	msg := reflect.New(p.msgInfo[id].msgType.Elem()).Interface()
+	i := p.msgInfo[id]
+
+	//按协议版本解码data
+	msg, err := i.versions.Decode(version, func() interface{} {
+		return i.protoType.New().Interface()
+	}, func(msg interface{}) error {
+		return proto.Unmarshal(data[headLen:], msg.(proto.Message))
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	//解码data
-	return msg, proto.UnmarshalMerge(data[2:], msg.(proto.Message))
+	//启用了序号，返回带序号的消息
+	if p.seq {
+		return &network.SeqMsg{Seq: seq, Msg: msg}, nil
+	}
+
+	return msg, nil
 }
 
-//编码消息
+//编码消息，消息经过编码中间件后再编码
 func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
-	//获取消息类型
-	msgType := reflect.TypeOf(msg)
+	return p.ApplyMarshal(msg, nil, p.marshal)
+}
+
+//编码
+func (p *Processor) marshal(msg interface{}, _ interface{}) ([][]byte, error) {
+	//带序号的消息，取出序号和消息
+	var seq uint32
+	if sm, ok := msg.(*network.SeqMsg); ok {
+		//未启用序号
+		if !p.seq {
+			return nil, errors.New("protobuf seq not enabled")
+		}
+
+		seq = sm.Seq
+		msg = sm.Msg
+	}
+
 	//获取消息ID
-	_id, ok := p.msgID[msgType]
+	_id, ok := p.msgID[msgName(msg)]
 
 	//消息未注册
 	if !ok {
-		err := fmt.Errorf("message %s not registered", msgType)
+		err := fmt.Errorf("message %s not registered", reflect.TypeOf(msg))
 		return nil, err
 	}
 
@@ -192,7 +442,72 @@ func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
 	//编码
 	data, err := proto.Marshal(msg.(proto.Message))
 
-	return [][]byte{id, data}, err
+	//未启用序号
+	if !p.seq {
+		return [][]byte{id, data}, err
+	}
+
+	//根据字节序将seq序列化到字节切片上
+	bufSeq := make([]byte, 4)
+	if p.littleEndian {
+		binary.LittleEndian.PutUint32(bufSeq, seq)
+	} else {
+		binary.BigEndian.PutUint32(bufSeq, seq)
+	}
+
+	return [][]byte{id, bufSeq, data}, err
+}
+
+//注册消息的旧版本
+//协议版本不大于maxVersion的客户端使用msg的消息ID发送old，解码后经upgrade转换为msg再路由
+func (p *Processor) RegisterVersion(msg proto.Message, maxVersion uint32, old proto.Message, upgrade func(old proto.Message) proto.Message) {
+	//获取消息全名
+	name := msgName(msg)
+	//获取消息ID
+	id, ok := p.msgID[name]
+
+	//消息未注册
+	if !ok {
+		log.Fatal("message %v not registered", name)
+	}
+
+	//旧版本消息类型
+	mt := old.ProtoReflect().Type()
+
+	//添加旧版本
+	err := p.msgInfo[id].versions.Add(maxVersion, func() interface{} {
+		return mt.New().Interface()
+	}, func(old interface{}) interface{} {
+		return upgrade(old.(proto.Message))
+	})
+	if err != nil {
+		log.Fatal("message %v version %v: %v", name, maxVersion, err)
+	}
+}
+
+//设置消息已弃用，收到该消息时计数并在第一次收到时输出日志
+func (p *Processor) SetDeprecated(msg proto.Message) {
+	//获取消息全名
+	name := msgName(msg)
+	//获取消息ID
+	id, ok := p.msgID[name]
+
+	//消息未注册
+	if !ok {
+		log.Fatal("message %v not registered", name)
+	}
+
+	p.msgInfo[id].versions.SetDeprecated()
+}
+
+//弃用消息（包括旧版本）的使用统计
+func (p *Processor) DeprecatedStats() []network.DeprecatedStat {
+	var stats []network.DeprecatedStat
+	for _, i := range p.msgInfo {
+		stats = append(stats, i.versions.Stats(string(i.protoType.Descriptor().FullName()))...)
+	}
+
+	return stats
 }
 
 //对所有消息应用函数
@@ -201,3 +516,108 @@ func (p *Processor) Range(f func(id uint16, t reflect.Type)) {
 		f(uint16(id), i.msgType)
 	}
 }
+
+//消息是否设置了处理函数或路由（即由服务器处理的消息）
+func (p *Processor) Handled(id uint16) bool {
+	//ID超出消息切片长度
+	if id >= uint16(len(p.msgInfo)) {
+		return false
+	}
+
+	i := p.msgInfo[id]
+	return i.msgHandler != nil || i.msgRouter != nil
+}
+
+//对所有消息的描述符应用函数
+func (p *Processor) RangeDescriptors(f func(id uint16, md protoreflect.MessageDescriptor)) {
+	for id, i := range p.msgInfo {
+		f(uint16(id), i.protoType.Descriptor())
+	}
+}
+
+//类型安全地设置消息处理函数，消息类型和用户数据类型由处理函数的参数推导
+//例如：protobuf.Handle(p, func(msg *pb.Login, a gate.Agent) {})
+//处理函数在读取消息的goroutine中执行
+func Handle[T proto.Message, U any](p *Processor, h func(msg T, userData U)) {
+	HandleSeq(p, func(msg T, userData U, _ uint32) {
+		h(msg, userData)
+	})
+}
+
+//与Handle相同，处理函数同时接收消息的序号（未启用序号时为0），用于回复请求
+//例如：protobuf.HandleSeq(p, func(msg *pb.Login, a gate.Agent, seq uint32) {})
+func HandleSeq[T proto.Message, U any](p *Processor, h func(msg T, userData U, seq uint32)) {
+	msg := typedMsg[T]()
+
+	p.SetHandler(msg, func(args []interface{}) {
+		h(typedArgs[T, U](args))
+	})
+}
+
+//类型安全地将消息路由到rpc服务器（或分片路由器），并以消息类型为id在rpc服务器上注册处理函数
+//例如：protobuf.HandleChanRPC(p, skeleton.ChanRPCServer, func(msg *pb.Login, a gate.Agent) {})
+//处理函数在rpc服务器所在模块的goroutine中执行
+func HandleChanRPC[T proto.Message, U any](p *Processor, server interface {
+	chanrpc.Router
+	Register(id interface{}, f interface{})
+}, h func(msg T, userData U)) {
+	HandleChanRPCSeq(p, server, func(msg T, userData U, _ uint32) {
+		h(msg, userData)
+	})
+}
+
+//与HandleChanRPC相同，处理函数同时接收消息的序号（未启用序号时为0），用于回复请求
+//例如：protobuf.HandleChanRPCSeq(p, skeleton.ChanRPCServer, func(msg *pb.Login, a gate.Agent, seq uint32) {})
+func HandleChanRPCSeq[T proto.Message, U any](p *Processor, server interface {
+	chanrpc.Router
+	Register(id interface{}, f interface{})
+}, h func(msg T, userData U, seq uint32)) {
+	msg := typedMsg[T]()
+
+	//在rpc服务器上注册处理函数
+	server.Register(reflect.TypeOf(msg), func(args []interface{}) {
+		h(typedArgs[T, U](args))
+	})
+
+	//设置路由
+	p.SetRouter(msg, server)
+}
+
+//获取T的零值，用于按类型查找消息
+//零值无法获取描述符的类型（如*dynamicpb.Message）不能用于类型安全的注册，需要使用SetHandler或SetRouterByName
+func typedMsg[T proto.Message]() T {
+	var msg T
+	if !hasDescriptor(msg) {
+		log.Fatal("message type %v has no static descriptor, use SetHandler or SetRouterByName with a message instance", reflect.TypeOf(msg))
+	}
+
+	return msg
+}
+
+//消息是否能获取描述符
+func hasDescriptor(msg proto.Message) (ok bool) {
+	if msg == nil {
+		return false
+	}
+
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+
+	return msg.ProtoReflect().Descriptor() != nil
+}
+
+//将路由参数[msg, userData, seq]转换为处理函数的参数
+func typedArgs[T proto.Message, U any](args []interface{}) (T, U, uint32) {
+	//用户数据为空时得到U的零值
+	userData, _ := args[1].(U)
+	//没有序号时为0
+	var seq uint32
+	if len(args) > 2 {
+		seq, _ = args[2].(uint32)
+	}
+
+	return args[0].(T), userData, seq
+}
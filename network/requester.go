@@ -0,0 +1,160 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+//请求超时错误
+var ErrRequestTimeout = errors.New("request timeout")
+
+//请求器，为客户端代理提供请求/响应关联
+//请求器为每个请求分配序号，代理读取到消息后先交给Dispatch，序号匹配的响应会交给对应的请求
+type Requester struct {
+	conn      Conn                //连接
+	processor Processor           //消息处理器（需要支持*SeqMsg）
+	mutex     sync.Mutex          //互斥锁
+	seq       uint32              //最后分配的序号
+	pending   map[uint32]*request //等待响应的请求，序号->请求
+	closeFlag bool                //关闭标志
+}
+
+//等待响应的请求
+type request struct {
+	cb func(interface{}, error) //回调
+	t  *time.Timer              //超时定时器，没有超时时为nil
+}
+
+//创建请求器
+func NewRequester(conn Conn, processor Processor) *Requester {
+	r := new(Requester)
+	r.conn = conn
+	r.processor = processor
+	r.pending = make(map[uint32]*request)
+	return r
+}
+
+//发送请求并阻塞等待响应，timeout不大于0时没有超时
+//不能在读取消息（调用Dispatch）的goroutine中调用，否则永远等不到响应
+func (r *Requester) Request(msg interface{}, timeout time.Duration) (interface{}, error) {
+	//用于接收结果
+	type result struct {
+		resp interface{}
+		err  error
+	}
+	chanRet := make(chan result, 1)
+
+	//发起异步请求
+	r.AsynRequest(msg, timeout, func(resp interface{}, err error) {
+		chanRet <- result{resp, err}
+	})
+
+	//等待结果
+	ret := <-chanRet
+	return ret.resp, ret.err
+}
+
+//发起异步请求，收到响应、超时或关闭时调用回调，timeout不大于0时没有超时
+//回调在调用Dispatch的goroutine、超时定时器的goroutine或者当前goroutine中执行
+func (r *Requester) AsynRequest(msg interface{}, timeout time.Duration, cb func(resp interface{}, err error)) {
+	//加锁
+	r.mutex.Lock()
+
+	//请求器已关闭
+	if r.closeFlag {
+		r.mutex.Unlock()
+		cb(nil, errors.New("requester closed"))
+		return
+	}
+
+	//分配序号，跳过0（单向消息）和回绕后仍在等待响应的序号
+	r.seq++
+	for r.seq == 0 || r.pending[r.seq] != nil {
+		r.seq++
+	}
+	seq := r.seq
+	//记录等待响应的请求
+	req := &request{cb: cb}
+	r.pending[seq] = req
+	//解锁
+	r.mutex.Unlock()
+
+	//编码并发送请求
+	data, err := r.processor.Marshal(&SeqMsg{Seq: seq, Msg: msg})
+	if err == nil {
+		err = r.conn.WriteMsg(data...)
+	}
+
+	//发送失败
+	if err != nil {
+		r.done(seq, nil, err)
+		return
+	}
+
+	//没有超时
+	if timeout <= 0 {
+		return
+	}
+
+	//超时后返回超时错误，请求已经结束时不再启动定时器
+	r.mutex.Lock()
+	if r.pending[seq] == req {
+		req.t = time.AfterFunc(timeout, func() {
+			r.done(seq, nil, ErrRequestTimeout)
+		})
+	}
+	r.mutex.Unlock()
+}
+
+//结束一个请求，请求仍在等待时调用回调
+func (r *Requester) done(seq uint32, resp interface{}, err error) bool {
+	//取出并删除等待的请求
+	r.mutex.Lock()
+	req := r.pending[seq]
+	delete(r.pending, seq)
+	r.mutex.Unlock()
+
+	//请求已经结束（已响应或已超时）
+	if req == nil {
+		return false
+	}
+
+	//停止超时定时器
+	if req.t != nil {
+		req.t.Stop()
+	}
+	req.cb(resp, err)
+	return true
+}
+
+//分发读取到的消息，是某个请求的响应时返回true，否则返回false（由调用者继续路由）
+func (r *Requester) Dispatch(msg interface{}) bool {
+	//只有带序号的消息才可能是响应
+	sm, ok := msg.(*SeqMsg)
+	if !ok || sm.Seq == 0 {
+		return false
+	}
+
+	return r.done(sm.Seq, sm.Msg, nil)
+}
+
+//关闭请求器，所有等待中的请求返回错误
+func (r *Requester) Close() {
+	//加锁
+	r.mutex.Lock()
+	//设置关闭标志
+	r.closeFlag = true
+	//取出所有等待中的请求
+	pending := r.pending
+	r.pending = make(map[uint32]*request)
+	//解锁
+	r.mutex.Unlock()
+
+	for _, req := range pending {
+		if req.t != nil {
+			req.t.Stop()
+		}
+		req.cb(nil, errors.New("requester closed"))
+	}
+}
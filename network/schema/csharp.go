@@ -0,0 +1,359 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+//C#关键字，作为标识符时需要加@前缀
+var csKeywords = map[string]bool{
+	"abstract": true, "as": true, "base": true, "bool": true, "break": true, "byte": true,
+	"case": true, "catch": true, "char": true, "checked": true, "class": true, "const": true,
+	"continue": true, "decimal": true, "default": true, "delegate": true, "do": true, "double": true,
+	"else": true, "enum": true, "event": true, "explicit": true, "extern": true, "false": true,
+	"finally": true, "fixed": true, "float": true, "for": true, "foreach": true, "goto": true,
+	"if": true, "implicit": true, "in": true, "int": true, "interface": true, "internal": true,
+	"is": true, "lock": true, "long": true, "namespace": true, "new": true, "null": true,
+	"object": true, "operator": true, "out": true, "override": true, "params": true, "private": true,
+	"protected": true, "public": true, "readonly": true, "ref": true, "return": true, "sbyte": true,
+	"sealed": true, "short": true, "sizeof": true, "stackalloc": true, "static": true, "string": true,
+	"struct": true, "switch": true, "this": true, "throw": true, "true": true, "try": true,
+	"typeof": true, "uint": true, "ulong": true, "unchecked": true, "unsafe": true, "ushort": true,
+	"using": true, "virtual": true, "void": true, "volatile": true, "while": true,
+}
+
+//生成C#客户端代码：消息类、帧格式常量、消息ID和帧的编码解码函数
+//protobuf消息体的编码解码由客户端的protobuf库完成，json消息体由客户端的json库完成
+func GenerateCSharp(s *Schema, namespace string) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "// Code generated by squash/network/schema. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "using System;\nusing System.Collections.Generic;\nusing System.Text;\n\n")
+	fmt.Fprintf(&b, "namespace %s\n{\n", namespace)
+
+	//枚举
+	for _, e := range s.Enums {
+		fmt.Fprintf(&b, "    public enum %s\n    {\n", ident(e.Name))
+		for _, v := range e.Values {
+			fmt.Fprintf(&b, "        %s = %d,\n", csIdent(v.Name), v.Number)
+		}
+		fmt.Fprintf(&b, "    }\n\n")
+	}
+
+	//消息和引用的类型
+	for _, m := range s.Messages {
+		csClass(&b, s, m)
+	}
+	for _, m := range s.Types {
+		csClass(&b, s, m)
+	}
+
+	fmt.Fprintf(&b, "    public static class Protocol\n    {\n")
+
+	//帧格式
+	fmt.Fprintf(&b, "        public static readonly int LenMsgLen = %d;\n", s.Framing.LenMsgLen)
+	fmt.Fprintf(&b, "        public static readonly bool LittleEndian = %v;\n", s.Framing.LittleEndian)
+	fmt.Fprintf(&b, "        public static readonly bool Seq = %v;\n", s.Framing.Seq)
+	fmt.Fprintf(&b, "        public static readonly bool Frag = %v;\n", s.Framing.Frag)
+	fmt.Fprintf(&b, "        public static readonly int MaxMsgLen = %d;\n\n", s.Framing.MaxMsgLen)
+
+	if s.Format == FormatProtobuf {
+		//消息ID
+		fmt.Fprintf(&b, "        public static class MsgID\n        {\n")
+		for _, m := range s.Messages {
+			fmt.Fprintf(&b, "            public const ushort %s = %d;\n", ident(m.Name), *m.ID)
+		}
+		fmt.Fprintf(&b, "        }\n\n")
+	} else {
+		//消息名
+		fmt.Fprintf(&b, "        public static readonly string[] MsgNames =\n        {\n")
+		for _, m := range s.Messages {
+			fmt.Fprintf(&b, "            %q,\n", m.Name)
+		}
+		fmt.Fprintf(&b, "        };\n\n")
+	}
+
+	b.WriteString(csLenFraming)
+	if s.Format == FormatProtobuf {
+		b.WriteString(csProtobufFraming)
+	} else {
+		b.WriteString(csJSONFraming)
+	}
+
+	fmt.Fprintf(&b, "    }\n}\n")
+
+	return b.Bytes()
+}
+
+//生成C#类
+func csClass(b *bytes.Buffer, s *Schema, m Message) {
+	if m.Direction != "" {
+		fmt.Fprintf(b, "    // %s\n", m.Direction)
+	}
+	fmt.Fprintf(b, "    public class %s\n    {\n", ident(m.Name))
+	for _, f := range m.Fields {
+		fmt.Fprintf(b, "        public %s %s;\n", csType(s, f.Type), csIdent(f.Name))
+	}
+	fmt.Fprintf(b, "    }\n\n")
+}
+
+//生成C#类型
+func csType(s *Schema, t *Type) string {
+	switch t.Kind {
+	case KindBool:
+		return "bool"
+	case KindInt32:
+		return "int"
+	case KindInt64:
+		return "long"
+	case KindUint32:
+		return "uint"
+	case KindUint64:
+		return "ulong"
+	case KindFloat:
+		return "float"
+	case KindDouble:
+		return "double"
+	case KindString:
+		return "string"
+	case KindBytes:
+		return "byte[]"
+	case KindEnum, KindMessage:
+		return ident(t.Name)
+	case KindList:
+		return fmt.Sprintf("List<%s>", csType(s, t.Elem))
+	case KindMap:
+		return fmt.Sprintf("Dictionary<%s, %s>", csType(s, t.Key), csType(s, t.Elem))
+	}
+
+	return "object"
+}
+
+//生成C#标识符
+func csIdent(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, name)
+
+	if csKeywords[name] {
+		return "@" + name
+	}
+
+	return name
+}
+
+//长度头的编码解码
+const csLenFraming = `        static void WriteUint(byte[] buf, int offset, int size, uint v)
+        {
+            for (int i = 0; i < size; i++)
+            {
+                int shift = LittleEndian ? i * 8 : (size - 1 - i) * 8;
+                buf[offset + i] = (byte)(v >> shift);
+            }
+        }
+
+        static uint ReadUint(byte[] buf, int offset, int size)
+        {
+            uint v = 0;
+            for (int i = 0; i < size; i++)
+            {
+                int shift = LittleEndian ? i * 8 : (size - 1 - i) * 8;
+                v |= (uint)buf[offset + i] << shift;
+            }
+            return v;
+        }
+
+        // Prepends the length header (TCP). WebSocket (LenMsgLen = 0) sends the body as is.
+        static byte[] PackLen(byte[] body)
+        {
+            if (LenMsgLen == 0)
+            {
+                return body;
+            }
+            byte[] buf = new byte[LenMsgLen + body.Length];
+            WriteUint(buf, 0, LenMsgLen, (uint)body.Length);
+            Buffer.BlockCopy(body, 0, buf, LenMsgLen, body.Length);
+            return buf;
+        }
+
+        // Finds the body in buf. Returns false if buf holds no complete message yet.
+        static bool UnpackLen(byte[] buf, int offset, int count, out int bodyOffset, out int bodyLen, out int size)
+        {
+            bodyOffset = offset + LenMsgLen;
+            bodyLen = count;
+            size = count;
+            if (LenMsgLen == 0)
+            {
+                return true;
+            }
+            if (count < LenMsgLen)
+            {
+                return false;
+            }
+            bodyLen = (int)ReadUint(buf, offset, LenMsgLen);
+            size = LenMsgLen + bodyLen;
+            return count >= size;
+        }
+
+        // Splits the body into fragments | flag | data | when Frag is set (flag 1: more fragments follow, 0: last)
+        // and prepends the length header to each. TCP can write the messages back to back; WebSocket sends each as one message.
+        static List<byte[]> Pack(byte[] body)
+        {
+            var msgs = new List<byte[]>();
+            if (!Frag)
+            {
+                msgs.Add(PackLen(body));
+                return msgs;
+            }
+            int offset = 0;
+            while (true)
+            {
+                int n = Math.Min(body.Length - offset, MaxMsgLen - 1);
+                bool last = offset + n == body.Length;
+                byte[] frag = new byte[1 + n];
+                frag[0] = (byte)(last ? 0 : 1);
+                Buffer.BlockCopy(body, offset, frag, 1, n);
+                msgs.Add(PackLen(frag));
+                offset += n;
+                if (last)
+                {
+                    return msgs;
+                }
+            }
+        }
+
+        // Finds the next body in buf. Returns false if buf holds no complete message yet.
+        // With Frag, frags keeps the fragments received so far (one list per connection), and a fragment
+        // that is not the last one is consumed with a null body.
+        static bool Unpack(byte[] buf, int offset, int count, List<byte[]> frags, out byte[] body, out int size)
+        {
+            body = null;
+            if (!UnpackLen(buf, offset, count, out int bodyOffset, out int bodyLen, out size))
+            {
+                return false;
+            }
+            if (!Frag)
+            {
+                body = new byte[bodyLen];
+                Buffer.BlockCopy(buf, bodyOffset, body, 0, bodyLen);
+                return true;
+            }
+            if (bodyLen < 1)
+            {
+                throw new FormatException("fragment too short");
+            }
+            byte flag = buf[bodyOffset];
+            if (flag > 1)
+            {
+                throw new FormatException("invalid fragment flag");
+            }
+            byte[] frag = new byte[bodyLen - 1];
+            Buffer.BlockCopy(buf, bodyOffset + 1, frag, 0, frag.Length);
+            frags.Add(frag);
+            if (flag == 1)
+            {
+                return true;
+            }
+            int total = 0;
+            foreach (byte[] f in frags)
+            {
+                total += f.Length;
+            }
+            body = new byte[total];
+            int pos = 0;
+            foreach (byte[] f in frags)
+            {
+                Buffer.BlockCopy(f, 0, body, pos, f.Length);
+                pos += f.Length;
+            }
+            frags.Clear();
+            return true;
+        }
+
+`
+
+//protobuf帧：| id | seq | data |
+const csProtobufFraming = `        static readonly int HeadLen = Seq ? 6 : 2;
+
+        // Encodes | len | id | seq | payload |. payload is the protobuf encoded message.
+        // Returns the messages to send in order (more than one only with Frag).
+        public static List<byte[]> EncodeFrame(ushort id, byte[] payload, uint seq = 0)
+        {
+            byte[] body = new byte[HeadLen + payload.Length];
+            WriteUint(body, 0, 2, id);
+            if (Seq)
+            {
+                WriteUint(body, 2, 4, seq);
+            }
+            Buffer.BlockCopy(payload, 0, body, HeadLen, payload.Length);
+            return Pack(body);
+        }
+
+        // Decodes one frame from buf. Returns false if buf holds no complete message yet.
+        // With Frag, pass the same frags list for every call on a connection; payload is null
+        // until the last fragment arrives.
+        public static bool TryDecodeFrame(byte[] buf, int offset, int count, out ushort id, out uint seq, out byte[] payload, out int size, List<byte[]> frags = null)
+        {
+            id = 0;
+            seq = 0;
+            payload = null;
+            if (!Unpack(buf, offset, count, frags, out byte[] body, out size))
+            {
+                return false;
+            }
+            if (body == null)
+            {
+                return true;
+            }
+            if (body.Length < HeadLen)
+            {
+                throw new FormatException("protobuf data too short");
+            }
+            id = (ushort)ReadUint(body, 0, 2);
+            if (Seq)
+            {
+                seq = ReadUint(body, 2, 4);
+            }
+            payload = new byte[body.Length - HeadLen];
+            Buffer.BlockCopy(body, HeadLen, payload, 0, payload.Length);
+            return true;
+        }
+`
+
+//json帧：{"msgID": {...}, "seq": 1}
+const csJSONFraming = `        // Encodes {"name": json, "seq": seq} with the length header. json is the encoded message body.
+        // Returns the messages to send in order (more than one only with Frag).
+        public static List<byte[]> EncodeFrame(string name, string json, uint? seq = null)
+        {
+            var sb = new StringBuilder();
+            sb.Append("{\"").Append(name).Append("\":").Append(json);
+            if (seq.HasValue)
+            {
+                sb.Append(",\"seq\":").Append(seq.Value);
+            }
+            sb.Append('}');
+            return Pack(Encoding.UTF8.GetBytes(sb.ToString()));
+        }
+
+        // Decodes one frame from buf into its json text ({"name": {...}, "seq": 1}).
+        // Returns false if buf holds no complete message yet.
+        // With Frag, pass the same frags list for every call on a connection; json is null
+        // until the last fragment arrives.
+        public static bool TryDecodeFrame(byte[] buf, int offset, int count, out string json, out int size, List<byte[]> frags = null)
+        {
+            json = null;
+            if (!Unpack(buf, offset, count, frags, out byte[] body, out size))
+            {
+                return false;
+            }
+            if (body != null)
+            {
+                json = Encoding.UTF8.GetString(body);
+            }
+            return true;
+        }
+`
@@ -0,0 +1,427 @@
+package schema
+
+import (
+	"encoding"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"squash/network/protobuf"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+//消息格式
+const (
+	FormatProtobuf = "protobuf" //protobuf处理器
+	FormatJSON     = "json"     //json处理器
+)
+
+//消息方向
+const (
+	ClientToServer = "c2s" //客户端发往服务器（服务器设置了处理函数或路由）
+	ServerToClient = "s2c" //服务器发往客户端
+)
+
+//类型种类
+const (
+	KindBool    = "bool"
+	KindInt32   = "int32"
+	KindInt64   = "int64"
+	KindUint32  = "uint32"
+	KindUint64  = "uint64"
+	KindFloat   = "float"
+	KindDouble  = "double"
+	KindString  = "string"
+	KindBytes   = "bytes"
+	KindEnum    = "enum"
+	KindMessage = "message"
+	KindList    = "list"
+	KindMap     = "map"
+	KindAny     = "any"
+)
+
+//消息描述
+type Schema struct {
+	Format   string    `json:"format"`          //消息格式
+	Framing  Framing   `json:"framing"`         //帧格式
+	Messages []Message `json:"messages"`        //注册的消息
+	Types    []Message `json:"types,omitempty"` //消息字段引用的其他类型
+	Enums    []Enum    `json:"enums,omitempty"` //消息字段引用的枚举
+}
+
+//帧格式，需要与gate（MsgParser、分片）和处理器的设置一致
+//TCP：| len | id | seq | data |，len为LenMsgLen字节，长度不包含len本身
+//WebSocket：| id | seq | data |，每个ws消息为一条消息，LenMsgLen为0
+//json格式没有id，消息为{"msgID": {...}, "seq": 1}
+//启用分片（gate设置了MaxTotalMsgLen）时，| id | seq | data |被拆分成多个分片，每个分片为一条消息：
+//TCP：| len | flag | fragment |，WebSocket：| flag | fragment |，flag为1表示后面还有分片，为0表示最后一个分片
+type Framing struct {
+	LenMsgLen    int    `json:"lenMsgLen"`           //消息长度占用字节数（1、2、4，WebSocket为0）
+	LittleEndian bool   `json:"littleEndian"`        //长度、id和序号是否小端
+	Seq          bool   `json:"seq"`                 //是否启用序号
+	Frag         bool   `json:"frag"`                //是否启用分片
+	MaxMsgLen    uint32 `json:"maxMsgLen,omitempty"` //单条消息的最大长度（gate的MaxMsgLen，启用分片时用于拆分）
+}
+
+//消息
+type Message struct {
+	ID        *uint16 `json:"id,omitempty"`        //消息ID（仅protobuf）
+	Name      string  `json:"name"`                //消息名（protobuf为全名）
+	Direction string  `json:"direction,omitempty"` //消息方向
+	Fields    []Field `json:"fields"`              //字段
+}
+
+//字段
+type Field struct {
+	Name   string `json:"name"`             //字段名（json为编码后的键）
+	Number int    `json:"number,omitempty"` //protobuf字段编号
+	Type   *Type  `json:"type"`             //字段类型
+}
+
+//类型
+type Type struct {
+	Kind string `json:"kind"`           //种类
+	Name string `json:"name,omitempty"` //消息或枚举名（种类为message或enum时）
+	Key  *Type  `json:"key,omitempty"`  //键类型（种类为map时）
+	Elem *Type  `json:"elem,omitempty"` //元素类型（种类为list或map时）
+}
+
+//枚举
+type Enum struct {
+	Name   string      `json:"name"`   //枚举名（全名）
+	Values []EnumValue `json:"values"` //枚举值
+}
+
+//枚举值
+type EnumValue struct {
+	Name   string `json:"name"`   //名字
+	Number int32  `json:"number"` //值
+}
+
+//从protobuf处理器的注册信息生成消息描述
+func FromProtobuf(p *protobuf.Processor, framing Framing) *Schema {
+	s := &Schema{Format: FormatProtobuf, Framing: framing}
+	b := newBuilder()
+
+	//注册的消息
+	p.RangeDescriptors(func(id uint16, md protoreflect.MessageDescriptor) {
+		b.seen[string(md.FullName())] = true
+
+		m := b.protoMessage(md)
+		m.ID = new(uint16)
+		*m.ID = id
+		m.Direction = direction(p.Handled(id))
+		s.Messages = append(s.Messages, m)
+	})
+
+	//字段引用的其他类型（处理过程中可能引用更多类型）
+	for len(b.pendingProto) > 0 {
+		md := b.pendingProto[0]
+		b.pendingProto = b.pendingProto[1:]
+		s.Types = append(s.Types, b.protoMessage(md))
+	}
+	s.Enums = b.enums
+
+	return s
+}
+
+//从json处理器的注册信息生成消息描述
+func FromJSON(p interface {
+	Range(f func(id string, t reflect.Type))
+	Handled(id string) bool
+}, framing Framing) *Schema {
+	s := &Schema{Format: FormatJSON, Framing: framing}
+	b := newBuilder()
+
+	//注册的消息
+	p.Range(func(id string, t reflect.Type) {
+		b.seen[id] = true
+		b.goNames[t.Elem()] = id
+
+		m := b.goMessage(id, t.Elem())
+		m.Direction = direction(p.Handled(id))
+		s.Messages = append(s.Messages, m)
+	})
+
+	//字段引用的其他类型
+	for len(b.pendingGo) > 0 {
+		t := b.pendingGo[0]
+		b.pendingGo = b.pendingGo[1:]
+		s.Types = append(s.Types, b.goMessage(b.goNames[t], t))
+	}
+
+	return s
+}
+
+//将消息描述编码为json
+func (s *Schema) MarshalIndent() ([]byte, error) {
+	return json.MarshalIndent(s, "", "\t")
+}
+
+//将消息描述和客户端代码写入目录
+//schema.json：消息描述
+//messages.ts：TypeScript客户端代码
+//Messages.cs：C#客户端代码（命名空间为Messages）
+func Export(s *Schema, dir string) error {
+	//创建目录
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	data, err := s.MarshalIndent()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "schema.json"), data, 0644); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "messages.ts"), GenerateTypeScript(s), 0644); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, "Messages.cs"), GenerateCSharp(s, "Messages"), 0644)
+}
+
+//根据是否由服务器处理得到消息方向
+func direction(handled bool) string {
+	if handled {
+		return ClientToServer
+	}
+
+	return ServerToClient
+}
+
+//类型收集器
+type builder struct {
+	seen         map[string]bool                  //已使用的消息、类型和枚举名
+	goNames      map[reflect.Type]string          //已收集的go类型->类型名
+	pendingProto []protoreflect.MessageDescriptor //待处理的protobuf类型
+	pendingGo    []reflect.Type                   //待处理的go类型
+	enums        []Enum                           //收集到的枚举
+}
+
+//创建类型收集器
+func newBuilder() *builder {
+	return &builder{seen: make(map[string]bool), goNames: make(map[reflect.Type]string)}
+}
+
+//生成protobuf消息
+func (b *builder) protoMessage(md protoreflect.MessageDescriptor) Message {
+	m := Message{Name: string(md.FullName()), Fields: []Field{}}
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		m.Fields = append(m.Fields, Field{
+			Name:   string(fd.Name()),
+			Number: int(fd.Number()),
+			Type:   b.protoField(fd),
+		})
+	}
+
+	return m
+}
+
+//生成protobuf字段类型
+func (b *builder) protoField(fd protoreflect.FieldDescriptor) *Type {
+	//map字段
+	if fd.IsMap() {
+		return &Type{Kind: KindMap, Key: b.protoKind(fd.MapKey()), Elem: b.protoKind(fd.MapValue())}
+	}
+
+	//repeated字段
+	if fd.IsList() {
+		return &Type{Kind: KindList, Elem: b.protoKind(fd)}
+	}
+
+	return b.protoKind(fd)
+}
+
+//生成protobuf字段的单值类型
+func (b *builder) protoKind(fd protoreflect.FieldDescriptor) *Type {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return &Type{Kind: KindBool}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return &Type{Kind: KindInt32}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return &Type{Kind: KindUint32}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return &Type{Kind: KindInt64}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return &Type{Kind: KindUint64}
+	case protoreflect.FloatKind:
+		return &Type{Kind: KindFloat}
+	case protoreflect.DoubleKind:
+		return &Type{Kind: KindDouble}
+	case protoreflect.StringKind:
+		return &Type{Kind: KindString}
+	case protoreflect.BytesKind:
+		return &Type{Kind: KindBytes}
+	case protoreflect.EnumKind:
+		ed := fd.Enum()
+		name := string(ed.FullName())
+
+		//收集枚举
+		if !b.seen[name] {
+			b.seen[name] = true
+
+			e := Enum{Name: name}
+			values := ed.Values()
+			for i := 0; i < values.Len(); i++ {
+				v := values.Get(i)
+				e.Values = append(e.Values, EnumValue{Name: string(v.Name()), Number: int32(v.Number())})
+			}
+			b.enums = append(b.enums, e)
+		}
+
+		return &Type{Kind: KindEnum, Name: name}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		md := fd.Message()
+		name := string(md.FullName())
+
+		//收集引用的消息
+		if !b.seen[name] {
+			b.seen[name] = true
+			b.pendingProto = append(b.pendingProto, md)
+		}
+
+		return &Type{Kind: KindMessage, Name: name}
+	}
+
+	return &Type{Kind: KindAny}
+}
+
+//实现了json.Marshaler和encoding.TextMarshaler的类型
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+//生成json消息（t为结构体类型）
+func (b *builder) goMessage(name string, t reflect.Type) Message {
+	m := Message{Name: name, Fields: []Field{}}
+	b.goFields(t, &m.Fields)
+
+	return m
+}
+
+//按encoding/json的规则收集结构体字段
+func (b *builder) goFields(t reflect.Type, fields *[]Field) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		//解析json标签
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		//没有标签的嵌入结构体，字段展开到外层
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			b.goFields(ft, fields)
+			continue
+		}
+
+		//跳过未导出字段
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		*fields = append(*fields, Field{Name: name, Type: b.goType(f.Type)})
+	}
+}
+
+//生成go类型对应的类型
+func (b *builder) goType(t reflect.Type) *Type {
+	//自定义编码的类型
+	if t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType) {
+		return &Type{Kind: KindAny}
+	}
+	if t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return &Type{Kind: KindString}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Type{Kind: KindBool}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Type{Kind: KindInt32}
+	case reflect.Int, reflect.Int64:
+		return &Type{Kind: KindInt64}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Type{Kind: KindUint32}
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return &Type{Kind: KindUint64}
+	case reflect.Float32:
+		return &Type{Kind: KindFloat}
+	case reflect.Float64:
+		return &Type{Kind: KindDouble}
+	case reflect.String:
+		return &Type{Kind: KindString}
+	case reflect.Ptr:
+		return b.goType(t.Elem())
+	case reflect.Slice, reflect.Array:
+		//[]byte编码为base64字符串
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return &Type{Kind: KindBytes}
+		}
+
+		return &Type{Kind: KindList, Elem: b.goType(t.Elem())}
+	case reflect.Map:
+		return &Type{Kind: KindMap, Key: b.goType(t.Key()), Elem: b.goType(t.Elem())}
+	case reflect.Struct:
+		//匿名结构体
+		if t.Name() == "" {
+			return &Type{Kind: KindAny}
+		}
+
+		//收集引用的结构体（以类型区分，不同包中的同名结构体是不同的类型）
+		name, ok := b.goNames[t]
+		if !ok {
+			name = b.goName(t)
+			b.seen[name] = true
+			b.goNames[t] = name
+			b.pendingGo = append(b.pendingGo, t)
+		}
+
+		return &Type{Kind: KindMessage, Name: name}
+	}
+
+	return &Type{Kind: KindAny}
+}
+
+//包路径中不能出现在标识符中的字符
+var pkgPathReplacer = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+//获取go结构体的类型名，默认为结构体名，与已使用的名字冲突时依次加上包名、完整包路径
+func (b *builder) goName(t reflect.Type) string {
+	name := t.Name()
+	if b.seen[name] {
+		name = pkgPathReplacer.ReplaceAllString(path.Base(t.PkgPath()), "_") + "." + t.Name()
+	}
+	if b.seen[name] {
+		name = pkgPathReplacer.ReplaceAllString(t.PkgPath(), "_") + "." + t.Name()
+	}
+
+	return name
+}
+
+//将消息名转换为客户端代码中的标识符（protobuf全名中的.替换为_）
+func ident(name string) string {
+	return strings.ReplaceAll(name, ".", "_")
+}
@@ -0,0 +1,177 @@
+package schema
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"squash/network/json"
+	"squash/network/protobuf"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+//更新golden文件：go test ./network/schema -update
+var update = flag.Bool("update", false, "update golden files")
+
+//导出的文件
+var exported = []string{"schema.json", "messages.ts", "Messages.cs"}
+
+//导出到临时目录，与testdata/dir中的golden文件比较
+func checkGolden(t *testing.T, s *Schema, dir string) {
+	out := t.TempDir()
+	if err := Export(s, out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range exported {
+		got, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		golden := filepath.Join("testdata", dir, name)
+		if *update {
+			if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%v differs from %v, run go test -update to regenerate:\n%s", name, golden, got)
+		}
+	}
+}
+
+//测试用的protobuf描述符：
+//
+//	package test;
+//	enum Color { RED = 0; GREEN = 1; }
+//	message Item { int32 count = 1; double price = 2; }
+//	message Login { string name = 1; int64 uid = 2; uint64 token = 3; repeated Color colors = 4; map<string, Item> items = 5; bytes data = 6; }
+//	message LoginResult { int32 code = 1; Item item = 2; }
+func testDescriptorSet() *descriptorpb.FileDescriptorSet {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, label descriptorpb.FieldDescriptorProto_Label) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Type:     typ.Enum(),
+			Label:    label.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+
+	return &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Color"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("RED"), Number: proto.Int32(0)},
+				{Name: proto.String("GREEN"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Item"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("count", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32, "", optional),
+					field("price", 2, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, "", optional),
+				},
+			},
+			{
+				Name: proto.String("Login"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", optional),
+					field("uid", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, "", optional),
+					field("token", 3, descriptorpb.FieldDescriptorProto_TYPE_UINT64, "", optional),
+					field("colors", 4, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".test.Color", repeated),
+					field("items", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Login.ItemsEntry", repeated),
+					field("data", 6, descriptorpb.FieldDescriptorProto_TYPE_BYTES, "", optional),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name: proto.String("ItemsEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", optional),
+						field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Item", optional),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+			},
+			{
+				Name: proto.String("LoginResult"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("code", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32, "", optional),
+					field("item", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Item", optional),
+				},
+			},
+		},
+	}}}
+}
+
+func TestProtobufGolden(t *testing.T) {
+	p := protobuf.NewProcessor()
+	p.SetSeq(true)
+	if err := p.SetDescriptorSet(testDescriptorSet()); err != nil {
+		t.Fatal(err)
+	}
+	p.RegisterByName("test.Login")
+	p.RegisterByName("test.LoginResult")
+
+	//Login为c2s
+	p.RangeDescriptors(func(id uint16, md protoreflect.MessageDescriptor) {
+		if md.FullName() == "test.Login" {
+			p.SetHandler(dynamicpb.NewMessage(md), func([]interface{}) {})
+		}
+	})
+
+	checkGolden(t, FromProtobuf(p, Framing{LenMsgLen: 2, Seq: true}), "protobuf")
+}
+
+type Item struct {
+	Count int32   `json:"count"`
+	Price float64 `json:"price"`
+}
+
+type Login struct {
+	Name   string          `json:"name"`
+	UID    int64           `json:"uid,string"`
+	Token  uint64          `json:"token,string"`
+	Tags   []string        `json:"tags,omitempty"`
+	Items  map[string]Item `json:"items"`
+	Data   []byte          `json:"data"`
+	secret string
+}
+
+type LoginResult struct {
+	Code int32 `json:"code"`
+	Item *Item `json:"item"`
+}
+
+func TestJSONGolden(t *testing.T) {
+	p := json.NewProcessor()
+	p.Register(&Login{})
+	p.Register(&LoginResult{})
+	p.SetHandler(&Login{}, func([]interface{}) {})
+
+	checkGolden(t, FromJSON(p, Framing{LenMsgLen: 2, Frag: true, MaxMsgLen: 4096}), "json")
+}
@@ -0,0 +1,206 @@
+// Code generated by squash/network/schema. DO NOT EDIT.
+
+using System;
+using System.Collections.Generic;
+using System.Text;
+
+namespace Messages
+{
+    // c2s
+    public class Login
+    {
+        public string name;
+        public long uid;
+        public ulong token;
+        public List<string> tags;
+        public Dictionary<string, Item> items;
+        public byte[] data;
+    }
+
+    // s2c
+    public class LoginResult
+    {
+        public int code;
+        public Item item;
+    }
+
+    public class Item
+    {
+        public int count;
+        public double price;
+    }
+
+    public static class Protocol
+    {
+        public static readonly int LenMsgLen = 2;
+        public static readonly bool LittleEndian = false;
+        public static readonly bool Seq = false;
+        public static readonly bool Frag = true;
+        public static readonly int MaxMsgLen = 4096;
+
+        public static readonly string[] MsgNames =
+        {
+            "Login",
+            "LoginResult",
+        };
+
+        static void WriteUint(byte[] buf, int offset, int size, uint v)
+        {
+            for (int i = 0; i < size; i++)
+            {
+                int shift = LittleEndian ? i * 8 : (size - 1 - i) * 8;
+                buf[offset + i] = (byte)(v >> shift);
+            }
+        }
+
+        static uint ReadUint(byte[] buf, int offset, int size)
+        {
+            uint v = 0;
+            for (int i = 0; i < size; i++)
+            {
+                int shift = LittleEndian ? i * 8 : (size - 1 - i) * 8;
+                v |= (uint)buf[offset + i] << shift;
+            }
+            return v;
+        }
+
+        // Prepends the length header (TCP). WebSocket (LenMsgLen = 0) sends the body as is.
+        static byte[] PackLen(byte[] body)
+        {
+            if (LenMsgLen == 0)
+            {
+                return body;
+            }
+            byte[] buf = new byte[LenMsgLen + body.Length];
+            WriteUint(buf, 0, LenMsgLen, (uint)body.Length);
+            Buffer.BlockCopy(body, 0, buf, LenMsgLen, body.Length);
+            return buf;
+        }
+
+        // Finds the body in buf. Returns false if buf holds no complete message yet.
+        static bool UnpackLen(byte[] buf, int offset, int count, out int bodyOffset, out int bodyLen, out int size)
+        {
+            bodyOffset = offset + LenMsgLen;
+            bodyLen = count;
+            size = count;
+            if (LenMsgLen == 0)
+            {
+                return true;
+            }
+            if (count < LenMsgLen)
+            {
+                return false;
+            }
+            bodyLen = (int)ReadUint(buf, offset, LenMsgLen);
+            size = LenMsgLen + bodyLen;
+            return count >= size;
+        }
+
+        // Splits the body into fragments | flag | data | when Frag is set (flag 1: more fragments follow, 0: last)
+        // and prepends the length header to each. TCP can write the messages back to back; WebSocket sends each as one message.
+        static List<byte[]> Pack(byte[] body)
+        {
+            var msgs = new List<byte[]>();
+            if (!Frag)
+            {
+                msgs.Add(PackLen(body));
+                return msgs;
+            }
+            int offset = 0;
+            while (true)
+            {
+                int n = Math.Min(body.Length - offset, MaxMsgLen - 1);
+                bool last = offset + n == body.Length;
+                byte[] frag = new byte[1 + n];
+                frag[0] = (byte)(last ? 0 : 1);
+                Buffer.BlockCopy(body, offset, frag, 1, n);
+                msgs.Add(PackLen(frag));
+                offset += n;
+                if (last)
+                {
+                    return msgs;
+                }
+            }
+        }
+
+        // Finds the next body in buf. Returns false if buf holds no complete message yet.
+        // With Frag, frags keeps the fragments received so far (one list per connection), and a fragment
+        // that is not the last one is consumed with a null body.
+        static bool Unpack(byte[] buf, int offset, int count, List<byte[]> frags, out byte[] body, out int size)
+        {
+            body = null;
+            if (!UnpackLen(buf, offset, count, out int bodyOffset, out int bodyLen, out size))
+            {
+                return false;
+            }
+            if (!Frag)
+            {
+                body = new byte[bodyLen];
+                Buffer.BlockCopy(buf, bodyOffset, body, 0, bodyLen);
+                return true;
+            }
+            if (bodyLen < 1)
+            {
+                throw new FormatException("fragment too short");
+            }
+            byte flag = buf[bodyOffset];
+            if (flag > 1)
+            {
+                throw new FormatException("invalid fragment flag");
+            }
+            byte[] frag = new byte[bodyLen - 1];
+            Buffer.BlockCopy(buf, bodyOffset + 1, frag, 0, frag.Length);
+            frags.Add(frag);
+            if (flag == 1)
+            {
+                return true;
+            }
+            int total = 0;
+            foreach (byte[] f in frags)
+            {
+                total += f.Length;
+            }
+            body = new byte[total];
+            int pos = 0;
+            foreach (byte[] f in frags)
+            {
+                Buffer.BlockCopy(f, 0, body, pos, f.Length);
+                pos += f.Length;
+            }
+            frags.Clear();
+            return true;
+        }
+
+        // Encodes {"name": json, "seq": seq} with the length header. json is the encoded message body.
+        // Returns the messages to send in order (more than one only with Frag).
+        public static List<byte[]> EncodeFrame(string name, string json, uint? seq = null)
+        {
+            var sb = new StringBuilder();
+            sb.Append("{\"").Append(name).Append("\":").Append(json);
+            if (seq.HasValue)
+            {
+                sb.Append(",\"seq\":").Append(seq.Value);
+            }
+            sb.Append('}');
+            return Pack(Encoding.UTF8.GetBytes(sb.ToString()));
+        }
+
+        // Decodes one frame from buf into its json text ({"name": {...}, "seq": 1}).
+        // Returns false if buf holds no complete message yet.
+        // With Frag, pass the same frags list for every call on a connection; json is null
+        // until the last fragment arrives.
+        public static bool TryDecodeFrame(byte[] buf, int offset, int count, out string json, out int size, List<byte[]> frags = null)
+        {
+            json = null;
+            if (!Unpack(buf, offset, count, frags, out byte[] body, out size))
+            {
+                return false;
+            }
+            if (body != null)
+            {
+                json = Encoding.UTF8.GetString(body);
+            }
+            return true;
+        }
+    }
+}
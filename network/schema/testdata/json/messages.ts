@@ -0,0 +1,164 @@
+// Code generated by squash/network/schema. DO NOT EDIT.
+
+export const LEN_MSG_LEN = 2;
+export const LITTLE_ENDIAN = false;
+export const SEQ = false;
+export const FRAG = true;
+export const MAX_MSG_LEN = 4096;
+
+// c2s
+export interface Login {
+	name: string;
+	uid: string;
+	token: string;
+	tags: string[];
+	items: { [key: string]: Item };
+	data: string;
+}
+
+// s2c
+export interface LoginResult {
+	code: number;
+	item: Item;
+}
+
+export interface Item {
+	count: number;
+	price: number;
+}
+
+export const MsgNames = [
+	"Login",
+	"LoginResult",
+] as const;
+
+function writeUint(view: DataView, offset: number, size: number, v: number): void {
+	switch (size) {
+		case 1: view.setUint8(offset, v); break;
+		case 2: view.setUint16(offset, v, LITTLE_ENDIAN); break;
+		case 4: view.setUint32(offset, v, LITTLE_ENDIAN); break;
+	}
+}
+
+function readUint(view: DataView, offset: number, size: number): number {
+	switch (size) {
+		case 1: return view.getUint8(offset);
+		case 2: return view.getUint16(offset, LITTLE_ENDIAN);
+		case 4: return view.getUint32(offset, LITTLE_ENDIAN);
+	}
+	return 0;
+}
+
+// Prepends the length header (TCP). WebSocket (LEN_MSG_LEN = 0) sends the body as is.
+function packLen(body: Uint8Array): Uint8Array {
+	if (LEN_MSG_LEN === 0) {
+		return body;
+	}
+	const buf = new Uint8Array(LEN_MSG_LEN + body.length);
+	writeUint(new DataView(buf.buffer), 0, LEN_MSG_LEN, body.length);
+	buf.set(body, LEN_MSG_LEN);
+	return buf;
+}
+
+// Returns the body and the number of bytes consumed, or null if buf holds no complete message yet.
+function unpackLen(buf: Uint8Array): { body: Uint8Array; size: number } | null {
+	if (LEN_MSG_LEN === 0) {
+		return { body: buf, size: buf.length };
+	}
+	if (buf.length < LEN_MSG_LEN) {
+		return null;
+	}
+	const len = readUint(new DataView(buf.buffer, buf.byteOffset, buf.byteLength), 0, LEN_MSG_LEN);
+	if (buf.length < LEN_MSG_LEN + len) {
+		return null;
+	}
+	return { body: buf.subarray(LEN_MSG_LEN, LEN_MSG_LEN + len), size: LEN_MSG_LEN + len };
+}
+
+// Splits the body into fragments | flag | data | when FRAG is set (flag 1: more fragments follow, 0: last)
+// and prepends the length header to each. TCP can write the messages back to back; WebSocket sends each as one message.
+function pack(body: Uint8Array): Uint8Array[] {
+	if (!FRAG) {
+		return [packLen(body)];
+	}
+	const msgs: Uint8Array[] = [];
+	let offset = 0;
+	for (;;) {
+		const n = Math.min(body.length - offset, MAX_MSG_LEN - 1);
+		const last = offset + n === body.length;
+		const frag = new Uint8Array(1 + n);
+		frag[0] = last ? 0 : 1;
+		frag.set(body.subarray(offset, offset + n), 1);
+		msgs.push(packLen(frag));
+		offset += n;
+		if (last) {
+			return msgs;
+		}
+	}
+}
+
+// Returns the next body and the number of bytes consumed, or null if buf holds no complete message yet.
+// With FRAG, frags keeps the fragments received so far (one array per connection), and a fragment
+// that is not the last one is consumed with a null body.
+function unpack(buf: Uint8Array, frags: Uint8Array[]): { body: Uint8Array | null; size: number } | null {
+	const r = unpackLen(buf);
+	if (r === null || !FRAG) {
+		return r;
+	}
+	if (r.body.length < 1) {
+		throw new Error("fragment too short");
+	}
+	const flag = r.body[0];
+	if (flag > 1) {
+		throw new Error("invalid fragment flag");
+	}
+	frags.push(r.body.slice(1));
+	if (flag === 1) {
+		return { body: null, size: r.size };
+	}
+	const body = new Uint8Array(frags.reduce((n, f) => n + f.length, 0));
+	let offset = 0;
+	for (const f of frags) {
+		body.set(f, offset);
+		offset += f.length;
+	}
+	frags.length = 0;
+	return { body, size: r.size };
+}
+
+export interface Frame {
+	name: string;
+	seq?: number;
+	msg: unknown;
+}
+
+// Encodes {"name": msg, "seq": seq} with the length header.
+// Returns the messages to send in order (more than one only with FRAG).
+export function encodeFrame(name: string, msg: unknown, seq?: number): Uint8Array[] {
+	const obj: { [key: string]: unknown } = { [name]: msg };
+	if (seq !== undefined) {
+		obj["seq"] = seq;
+	}
+	return pack(new TextEncoder().encode(JSON.stringify(obj)));
+}
+
+// Decodes one frame from buf. Returns null if buf holds no complete message yet.
+// With FRAG, pass the same frags array for every call on a connection; frame is null
+// until the last fragment arrives.
+export function decodeFrame(buf: Uint8Array, frags: Uint8Array[] = []): { frame: Frame | null; size: number } | null {
+	const r = unpack(buf, frags);
+	if (r === null) {
+		return null;
+	}
+	if (r.body === null) {
+		return { frame: null, size: r.size };
+	}
+	const obj = JSON.parse(new TextDecoder().decode(r.body)) as { [key: string]: unknown };
+	const seq = obj["seq"] as number | undefined;
+	delete obj["seq"];
+	const names = Object.keys(obj);
+	if (names.length !== 1) {
+		throw new Error("invalid json data");
+	}
+	return { frame: { name: names[0], seq, msg: obj[names[0]] }, size: r.size };
+}
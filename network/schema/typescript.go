@@ -0,0 +1,308 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+//合法的标识符
+var identRegexp = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+//生成TypeScript客户端代码：帧格式常量、消息ID、消息接口和帧的编码解码函数
+//protobuf消息体的编码解码由客户端的protobuf库完成，json消息体使用JSON.stringify和JSON.parse
+func GenerateTypeScript(s *Schema) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "// Code generated by squash/network/schema. DO NOT EDIT.\n\n")
+
+	//帧格式
+	fmt.Fprintf(&b, "export const LEN_MSG_LEN = %d;\n", s.Framing.LenMsgLen)
+	fmt.Fprintf(&b, "export const LITTLE_ENDIAN = %v;\n", s.Framing.LittleEndian)
+	fmt.Fprintf(&b, "export const SEQ = %v;\n", s.Framing.Seq)
+	fmt.Fprintf(&b, "export const FRAG = %v;\n", s.Framing.Frag)
+	fmt.Fprintf(&b, "export const MAX_MSG_LEN = %d;\n\n", s.Framing.MaxMsgLen)
+
+	//枚举
+	for _, e := range s.Enums {
+		fmt.Fprintf(&b, "export enum %s {\n", ident(e.Name))
+		for _, v := range e.Values {
+			fmt.Fprintf(&b, "\t%s = %d,\n", v.Name, v.Number)
+		}
+		fmt.Fprintf(&b, "}\n\n")
+	}
+
+	//消息和引用的类型
+	for _, m := range s.Messages {
+		tsInterface(&b, s, m)
+	}
+	for _, m := range s.Types {
+		tsInterface(&b, s, m)
+	}
+
+	if s.Format == FormatProtobuf {
+		//消息ID
+		fmt.Fprintf(&b, "export const MsgID = {\n")
+		for _, m := range s.Messages {
+			fmt.Fprintf(&b, "\t%s: %d,\n", ident(m.Name), *m.ID)
+		}
+		fmt.Fprintf(&b, "} as const;\n\n")
+
+		fmt.Fprintf(&b, "export const MsgName: { [id: number]: string } = {\n")
+		for _, m := range s.Messages {
+			fmt.Fprintf(&b, "\t%d: %q,\n", *m.ID, m.Name)
+		}
+		fmt.Fprintf(&b, "};\n\n")
+	} else {
+		//消息名
+		fmt.Fprintf(&b, "export const MsgNames = [\n")
+		for _, m := range s.Messages {
+			fmt.Fprintf(&b, "\t%q,\n", m.Name)
+		}
+		fmt.Fprintf(&b, "] as const;\n\n")
+	}
+
+	b.WriteString(tsLenFraming)
+	if s.Format == FormatProtobuf {
+		b.WriteString(tsProtobufFraming)
+	} else {
+		b.WriteString(tsJSONFraming)
+	}
+
+	return b.Bytes()
+}
+
+//生成TypeScript接口
+func tsInterface(b *bytes.Buffer, s *Schema, m Message) {
+	if m.Direction != "" {
+		fmt.Fprintf(b, "// %s\n", m.Direction)
+	}
+	fmt.Fprintf(b, "export interface %s {\n", ident(m.Name))
+
+	//protobuf字段可以省略（取默认值）
+	optional := ""
+	if s.Format == FormatProtobuf {
+		optional = "?"
+	}
+
+	for _, f := range m.Fields {
+		name := f.Name
+		if !identRegexp.MatchString(name) {
+			name = fmt.Sprintf("%q", name)
+		}
+		fmt.Fprintf(b, "\t%s%s: %s;\n", name, optional, tsType(s, f.Type))
+	}
+	fmt.Fprintf(b, "}\n\n")
+}
+
+//生成TypeScript类型
+func tsType(s *Schema, t *Type) string {
+	switch t.Kind {
+	case KindBool:
+		return "boolean"
+	case KindInt32, KindUint32, KindFloat, KindDouble:
+		return "number"
+	case KindInt64, KindUint64:
+		//number超过2^53时丢失精度
+		//json中与protobuf的JSON映射一致编码为字符串（go字段需要使用,string标签），protobuf库解码为bigint
+		if s.Format == FormatJSON {
+			return "string"
+		}
+		return "bigint"
+	case KindString:
+		return "string"
+	case KindBytes:
+		//json中[]byte编码为base64字符串
+		if s.Format == FormatJSON {
+			return "string"
+		}
+		return "Uint8Array"
+	case KindEnum, KindMessage:
+		return ident(t.Name)
+	case KindList:
+		return tsType(s, t.Elem) + "[]"
+	case KindMap:
+		return fmt.Sprintf("{ [key: string]: %s }", tsType(s, t.Elem))
+	}
+
+	return "unknown"
+}
+
+//长度头的编码解码
+const tsLenFraming = `function writeUint(view: DataView, offset: number, size: number, v: number): void {
+	switch (size) {
+		case 1: view.setUint8(offset, v); break;
+		case 2: view.setUint16(offset, v, LITTLE_ENDIAN); break;
+		case 4: view.setUint32(offset, v, LITTLE_ENDIAN); break;
+	}
+}
+
+function readUint(view: DataView, offset: number, size: number): number {
+	switch (size) {
+		case 1: return view.getUint8(offset);
+		case 2: return view.getUint16(offset, LITTLE_ENDIAN);
+		case 4: return view.getUint32(offset, LITTLE_ENDIAN);
+	}
+	return 0;
+}
+
+// Prepends the length header (TCP). WebSocket (LEN_MSG_LEN = 0) sends the body as is.
+function packLen(body: Uint8Array): Uint8Array {
+	if (LEN_MSG_LEN === 0) {
+		return body;
+	}
+	const buf = new Uint8Array(LEN_MSG_LEN + body.length);
+	writeUint(new DataView(buf.buffer), 0, LEN_MSG_LEN, body.length);
+	buf.set(body, LEN_MSG_LEN);
+	return buf;
+}
+
+// Returns the body and the number of bytes consumed, or null if buf holds no complete message yet.
+function unpackLen(buf: Uint8Array): { body: Uint8Array; size: number } | null {
+	if (LEN_MSG_LEN === 0) {
+		return { body: buf, size: buf.length };
+	}
+	if (buf.length < LEN_MSG_LEN) {
+		return null;
+	}
+	const len = readUint(new DataView(buf.buffer, buf.byteOffset, buf.byteLength), 0, LEN_MSG_LEN);
+	if (buf.length < LEN_MSG_LEN + len) {
+		return null;
+	}
+	return { body: buf.subarray(LEN_MSG_LEN, LEN_MSG_LEN + len), size: LEN_MSG_LEN + len };
+}
+
+// Splits the body into fragments | flag | data | when FRAG is set (flag 1: more fragments follow, 0: last)
+// and prepends the length header to each. TCP can write the messages back to back; WebSocket sends each as one message.
+function pack(body: Uint8Array): Uint8Array[] {
+	if (!FRAG) {
+		return [packLen(body)];
+	}
+	const msgs: Uint8Array[] = [];
+	let offset = 0;
+	for (;;) {
+		const n = Math.min(body.length - offset, MAX_MSG_LEN - 1);
+		const last = offset + n === body.length;
+		const frag = new Uint8Array(1 + n);
+		frag[0] = last ? 0 : 1;
+		frag.set(body.subarray(offset, offset + n), 1);
+		msgs.push(packLen(frag));
+		offset += n;
+		if (last) {
+			return msgs;
+		}
+	}
+}
+
+// Returns the next body and the number of bytes consumed, or null if buf holds no complete message yet.
+// With FRAG, frags keeps the fragments received so far (one array per connection), and a fragment
+// that is not the last one is consumed with a null body.
+function unpack(buf: Uint8Array, frags: Uint8Array[]): { body: Uint8Array | null; size: number } | null {
+	const r = unpackLen(buf);
+	if (r === null || !FRAG) {
+		return r;
+	}
+	if (r.body.length < 1) {
+		throw new Error("fragment too short");
+	}
+	const flag = r.body[0];
+	if (flag > 1) {
+		throw new Error("invalid fragment flag");
+	}
+	frags.push(r.body.slice(1));
+	if (flag === 1) {
+		return { body: null, size: r.size };
+	}
+	const body = new Uint8Array(frags.reduce((n, f) => n + f.length, 0));
+	let offset = 0;
+	for (const f of frags) {
+		body.set(f, offset);
+		offset += f.length;
+	}
+	frags.length = 0;
+	return { body, size: r.size };
+}
+
+`
+
+//protobuf帧：| id | seq | data |
+const tsProtobufFraming = `export interface Frame {
+	id: number;
+	seq: number;
+	payload: Uint8Array;
+}
+
+const HEAD_LEN = SEQ ? 6 : 2;
+
+// Encodes | len | id | seq | payload |. payload is the protobuf encoded message.
+// Returns the messages to send in order (more than one only with FRAG).
+export function encodeFrame(id: number, payload: Uint8Array, seq = 0): Uint8Array[] {
+	const body = new Uint8Array(HEAD_LEN + payload.length);
+	const view = new DataView(body.buffer);
+	writeUint(view, 0, 2, id);
+	if (SEQ) {
+		writeUint(view, 2, 4, seq);
+	}
+	body.set(payload, HEAD_LEN);
+	return pack(body);
+}
+
+// Decodes one frame from buf. Returns null if buf holds no complete message yet.
+// With FRAG, pass the same frags array for every call on a connection; frame is null
+// until the last fragment arrives.
+export function decodeFrame(buf: Uint8Array, frags: Uint8Array[] = []): { frame: Frame | null; size: number } | null {
+	const r = unpack(buf, frags);
+	if (r === null) {
+		return null;
+	}
+	if (r.body === null) {
+		return { frame: null, size: r.size };
+	}
+	if (r.body.length < HEAD_LEN) {
+		throw new Error("protobuf data too short");
+	}
+	const view = new DataView(r.body.buffer, r.body.byteOffset, r.body.byteLength);
+	const id = readUint(view, 0, 2);
+	const seq = SEQ ? readUint(view, 2, 4) : 0;
+	return { frame: { id, seq, payload: r.body.subarray(HEAD_LEN) }, size: r.size };
+}
+`
+
+//json帧：{"msgID": {...}, "seq": 1}
+const tsJSONFraming = `export interface Frame {
+	name: string;
+	seq?: number;
+	msg: unknown;
+}
+
+// Encodes {"name": msg, "seq": seq} with the length header.
+// Returns the messages to send in order (more than one only with FRAG).
+export function encodeFrame(name: string, msg: unknown, seq?: number): Uint8Array[] {
+	const obj: { [key: string]: unknown } = { [name]: msg };
+	if (seq !== undefined) {
+		obj["seq"] = seq;
+	}
+	return pack(new TextEncoder().encode(JSON.stringify(obj)));
+}
+
+// Decodes one frame from buf. Returns null if buf holds no complete message yet.
+// With FRAG, pass the same frags array for every call on a connection; frame is null
+// until the last fragment arrives.
+export function decodeFrame(buf: Uint8Array, frags: Uint8Array[] = []): { frame: Frame | null; size: number } | null {
+	const r = unpack(buf, frags);
+	if (r === null) {
+		return null;
+	}
+	if (r.body === null) {
+		return { frame: null, size: r.size };
+	}
+	const obj = JSON.parse(new TextDecoder().decode(r.body)) as { [key: string]: unknown };
+	const seq = obj["seq"] as number | undefined;
+	delete obj["seq"];
+	const names = Object.keys(obj);
+	if (names.length !== 1) {
+		throw new Error("invalid json data");
+	}
+	return { frame: { name: names[0], seq, msg: obj[names[0]] }, size: r.size };
+}
+`
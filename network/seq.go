@@ -0,0 +1,9 @@
+package network
+
+//带序号的消息，用于请求/响应关联
+//处理器解码带序号的数据时返回*SeqMsg，编码时接受*SeqMsg或普通消息（普通消息序号为0）
+//路由时处理器会拆开*SeqMsg，消息处理函数的参数为[msg, userData, seq]，回复时使用相同的序号即可
+type SeqMsg struct {
+	Seq uint32      //请求序号，0表示单向消息
+	Msg interface{} //消息
+}
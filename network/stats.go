@@ -0,0 +1,86 @@
+package network
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+//连接统计
+type ConnStats struct {
+	BytesRead     uint64    //读取的字节数（tcp连接包含长度信息）
+	BytesWritten  uint64    //发送的字节数（tcp连接包含长度信息）
+	MsgsRead      uint64    //读取的消息数
+	MsgsWritten   uint64    //进入发送缓冲区的消息数
+	WriteQueueLen int       //发送缓冲区当前长度
+	WriteQueueCap int       //发送缓冲区容量
+	LastRead      time.Time //最后一次读取消息的时间
+	LastWrite     time.Time //最后一次发送数据的时间
+}
+
+//服务器统计
+type ServerStats struct {
+	ConnNum         int    //当前连接数
+	Accepted        uint64 //接受的连接总数
+	Rejected        uint64 //因超过最大连接数被拒绝的连接数
+	QueueFullClosed uint64 //因发送缓冲区已满被关闭的连接数
+}
+
+//连接计数器，字段均为原子操作
+type connCounter struct {
+	bytesRead    atomic.Uint64 //读取的字节数
+	bytesWritten atomic.Uint64 //发送的字节数
+	msgsRead     atomic.Uint64 //读取的消息数
+	msgsWritten  atomic.Uint64 //进入发送缓冲区的消息数
+	lastRead     atomic.Int64  //最后一次读取消息的时间（纳秒）
+	lastWrite    atomic.Int64  //最后一次发送数据的时间（纳秒）
+}
+
+//记录读取了一条消息
+func (c *connCounter) onRead(n int) {
+	c.bytesRead.Add(uint64(n))
+	c.msgsRead.Add(1)
+	c.lastRead.Store(time.Now().UnixNano())
+}
+
+//记录发送了n字节数据
+func (c *connCounter) onWrite(n int) {
+	c.bytesWritten.Add(uint64(n))
+	c.lastWrite.Store(time.Now().UnixNano())
+}
+
+//生成连接统计
+func (c *connCounter) stats() ConnStats {
+	s := ConnStats{
+		BytesRead:    c.bytesRead.Load(),
+		BytesWritten: c.bytesWritten.Load(),
+		MsgsRead:     c.msgsRead.Load(),
+		MsgsWritten:  c.msgsWritten.Load(),
+	}
+
+	//时间为0表示还没有读取或发送过
+	if t := c.lastRead.Load(); t != 0 {
+		s.LastRead = time.Unix(0, t)
+	}
+	if t := c.lastWrite.Load(); t != 0 {
+		s.LastWrite = time.Unix(0, t)
+	}
+
+	return s
+}
+
+//服务器计数器，字段均为原子操作
+type serverCounter struct {
+	accepted        atomic.Uint64 //接受的连接总数
+	rejected        atomic.Uint64 //被拒绝的连接数
+	queueFullClosed atomic.Uint64 //因发送缓冲区已满被关闭的连接数
+}
+
+//生成服务器统计
+func (c *serverCounter) stats(connNum int) ServerStats {
+	return ServerStats{
+		ConnNum:         connNum,
+		Accepted:        c.accepted.Load(),
+		Rejected:        c.rejected.Load(),
+		QueueFullClosed: c.queueFullClosed.Load(),
+	}
+}
@@ -15,7 +15,9 @@ type TCPConn struct {
 	conn       net.Conn    //底层连接
 	writeChan  chan []byte //发送缓冲
 	closeFlag  bool        //关闭标志
+	queueFull  bool        //是否因发送缓冲区已满被关闭
 	msgParser  *MsgParser  //消息解析器
+	counter    connCounter //连接计数器
 }
 
 //新建tcp连接
@@ -37,7 +39,9 @@ func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser *MsgParser) *TCPCo
 			}
 
 			//发送数据
-			_, err := conn.Write(b)
+			n, err := conn.Write(b)
+			//记录发送的字节数
+			tcpConn.counter.onWrite(n)
 
 			//发送失败
 			if err != nil {
@@ -73,16 +77,18 @@ func (tcpConn *TCPConn) doDestroy() {
 }
 
 //写操作
-func (tcpConn *TCPConn) doWrite(b []byte) {
+func (tcpConn *TCPConn) doWrite(b []byte) bool {
 	//发送缓冲区长度等于最大容量，输出日志"管道已满"，做销毁操作
 	if len(tcpConn.writeChan) == cap(tcpConn.writeChan) {
 		log.Debug("close conn: channel full")
+		tcpConn.queueFull = true
 		tcpConn.doDestroy()
-		return
+		return false
 	}
 
 	//将待发数据发送到发送缓冲区
 	tcpConn.writeChan <- b
+	return true
 }
 
 //从缓冲区读取数据
@@ -92,6 +98,11 @@ func (tcpConn *TCPConn) Read(b []byte) (int, error) {
 
 //写数据到缓冲区
 func (tcpConn *TCPConn) Write(b []byte) {
+	tcpConn.write(b)
+}
+
+//写数据到缓冲区，返回是否进入了发送缓冲区
+func (tcpConn *TCPConn) write(b []byte) bool {
 	//加锁
 	tcpConn.Lock()
 	//延迟解锁
@@ -99,23 +110,83 @@ func (tcpConn *TCPConn) Write(b []byte) {
 
 	//连接已关闭或者传入的b为空
 	if tcpConn.closeFlag || b == nil {
-		return
+		return false
 	}
 
 	//写操作
-	tcpConn.doWrite(b)
+	return tcpConn.doWrite(b)
 }
 
 //读取消息
 func (tcpConn *TCPConn) ReadMsg() ([]byte, error) {
 	//使用消息解析器读取
-	return tcpConn.msgParser.Read(tcpConn)
+	b, err := tcpConn.msgParser.Read(tcpConn)
+	if err != nil {
+		return nil, err
+	}
+
+	//记录读取的消息
+	tcpConn.counter.onRead(tcpConn.msgParser.lenMsgLen + len(b))
+
+	return b, nil
 }
 
 //发送消息
 func (tcpConn *TCPConn) WriteMsg(args ...[]byte) error {
-	//使用消息解析器发送
-	return tcpConn.msgParser.Write(tcpConn, args...)
+	//使用消息解析器打包
+	msg, err := tcpConn.msgParser.pack(args...)
+	if err != nil {
+		return err
+	}
+
+	//写数据到缓冲区，进入发送缓冲区后记录发送的消息
+	if tcpConn.write(msg) {
+		tcpConn.counter.msgsWritten.Add(1)
+	}
+
+	return nil
+}
+
+//一次发送多条消息，多条消息作为一个整体进入发送缓冲区（用于分片）
+func (tcpConn *TCPConn) writeMsgs(frameType int, msgs [][]byte) error {
+	var b []byte
+
+	//打包所有消息并合并
+	for _, m := range msgs {
+		msg, err := tcpConn.msgParser.pack(m)
+		if err != nil {
+			return err
+		}
+
+		b = append(b, msg...)
+	}
+
+	//写数据到缓冲区，进入发送缓冲区后记录发送的消息
+	if tcpConn.write(b) {
+		tcpConn.counter.msgsWritten.Add(uint64(len(msgs)))
+	}
+
+	return nil
+}
+
+//返回单条消息的最大长度
+func (tcpConn *TCPConn) MaxMsgLen() uint32 {
+	return tcpConn.msgParser.MaxMsgLen()
+}
+
+//返回连接统计
+func (tcpConn *TCPConn) Stats() ConnStats {
+	s := tcpConn.counter.stats()
+	s.WriteQueueLen = len(tcpConn.writeChan)
+	s.WriteQueueCap = cap(tcpConn.writeChan)
+	return s
+}
+
+//是否因发送缓冲区已满被关闭
+func (tcpConn *TCPConn) closedByQueueFull() bool {
+	tcpConn.Lock()
+	defer tcpConn.Unlock()
+	return tcpConn.queueFull
 }
 
 //返回本地地址
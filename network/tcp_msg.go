@@ -123,8 +123,27 @@ func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
 	return msgData, nil
 }
 
+//返回最大消息长度
+func (p *MsgParser) MaxMsgLen() uint32 {
+	return p.maxMsgLen
+}
+
 //发送消息
 func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
+	//打包消息
+	msg, err := p.pack(args...)
+	if err != nil {
+		return err
+	}
+
+	//发送数据
+	conn.Write(msg)
+
+	return nil
+}
+
+//打包消息，返回带长度信息的完整数据
+func (p *MsgParser) pack(args ...[]byte) ([]byte, error) {
 	var msgLen uint32
 
 	//计算消息长度
@@ -134,9 +153,9 @@ func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
 
 	//检查长度是否合法
 	if msgLen > p.maxMsgLen {
-		return errors.New("message too long")
+		return nil, errors.New("message too long")
 	} else if msgLen < p.minMsgLen {
-		return errors.New("message too short")
+		return nil, errors.New("message too short")
 	}
 
 	//创建(lenMsgLen+msgLen)长度的字节切片
@@ -168,8 +187,5 @@ func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
 		l += len(args[i])
 	}
 
-	//发送数据
-	conn.Write(msg)
-
-	return nil
+	return msg, nil
 }
@@ -18,6 +18,7 @@ type TCPServer struct {
 	mutexConns      sync.Mutex           //互斥锁
 	wgLn            sync.WaitGroup       //监听器等待组
 	wgConns         sync.WaitGroup       //连接等待组
+	counter         serverCounter        //服务器计数器
 
 	//消息解析器
 	LenMsgLen    int        //消息长度占用字节数
@@ -134,6 +135,7 @@ func (server *TCPServer) run() {
 			server.mutexConns.Unlock()
 			conn.Close()
 			log.Debug("too many connections")
+			server.counter.rejected.Add(1)
 			continue
 		}
 
@@ -141,6 +143,8 @@ func (server *TCPServer) run() {
 		server.conns[conn] = struct{}{} //struct{}为类型，第二个{}为初始化，只不过是空值而已
 		//解锁
 		server.mutexConns.Unlock()
+		//记录接受的连接
+		server.counter.accepted.Add(1)
 		//连接等待组+1
 		server.wgConns.Add(1)
 		//创建一个tcp连接
@@ -156,6 +160,10 @@ func (server *TCPServer) run() {
 			/*清理工作开始*/
 			//关闭连接
 			tcpConn.Close()
+			//记录因发送缓冲区已满被关闭的连接
+			if tcpConn.closedByQueueFull() {
+				server.counter.queueFullClosed.Add(1)
+			}
 			//加锁
 			server.mutexConns.Lock()
 			//从连接集合中删除连接
@@ -192,3 +200,12 @@ func (server *TCPServer) Close() {
 	//等待所有连接的goroutine退出
 	server.wgConns.Wait()
 }
+
+//返回服务器统计
+func (server *TCPServer) Stats() ServerStats {
+	server.mutexConns.Lock()
+	connNum := len(server.conns)
+	server.mutexConns.Unlock()
+
+	return server.counter.stats(connNum)
+}
@@ -0,0 +1,130 @@
+package network
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"squash/log"
+	"sync/atomic"
+)
+
+//版本化处理器接口（可选），处理器实现该接口后可以按客户端的协议版本解码消息
+type VersionedProcessor interface {
+	UnmarshalVersion(data []byte, version uint32) (interface{}, error) //按协议版本解码，version为0时使用最新版本
+}
+
+//弃用消息的使用统计
+type DeprecatedStat struct {
+	Name       string //消息名
+	MaxVersion uint32 //旧版本适用的最大协议版本，为0时表示弃用的最新版本
+	Count      uint64 //使用次数
+}
+
+//消息版本，记录一条消息的旧版本和弃用状态，零值可用
+//协议版本不大于旧版本maxVersion的客户端发送的消息按旧版本解码，再通过升级函数转换为最新版本
+//旧版本都视为已弃用，使用时计数并在第一次使用时输出日志
+type MsgVersions struct {
+	deprecated bool          //最新版本是否已弃用
+	count      atomic.Uint64 //弃用的最新版本的使用次数
+	versions   []*oldVersion //旧版本，按maxVersion升序
+}
+
+//消息的旧版本
+type oldVersion struct {
+	maxVersion uint32                            //适用的最大协议版本
+	newMsg     func() interface{}                //创建旧版本消息
+	upgrade    func(old interface{}) interface{} //升级函数，将旧版本消息转换为最新版本
+	count      atomic.Uint64                     //使用次数
+}
+
+//添加旧版本
+func (v *MsgVersions) Add(maxVersion uint32, newMsg func() interface{}, upgrade func(old interface{}) interface{}) error {
+	//协议版本0表示最新版本
+	if maxVersion == 0 {
+		return errors.New("max version must be greater than 0")
+	}
+
+	//升级函数不能为空
+	if upgrade == nil {
+		return errors.New("upgrade function required")
+	}
+
+	//同一版本只能有一个旧版本
+	for _, o := range v.versions {
+		if o.maxVersion == maxVersion {
+			return errors.New("version already registered")
+		}
+	}
+
+	v.versions = append(v.versions, &oldVersion{maxVersion: maxVersion, newMsg: newMsg, upgrade: upgrade})
+	sort.Slice(v.versions, func(i, j int) bool {
+		return v.versions[i].maxVersion < v.versions[j].maxVersion
+	})
+
+	return nil
+}
+
+//设置最新版本已弃用
+func (v *MsgVersions) SetDeprecated() {
+	v.deprecated = true
+}
+
+//按协议版本解码消息
+//newMsg创建最新版本消息，decode将数据解码到消息中，返回的消息为最新版本
+func (v *MsgVersions) Decode(version uint32, newMsg func() interface{}, decode func(msg interface{}) error) (interface{}, error) {
+	//查找适用的旧版本（协议版本为0时使用最新版本）
+	var o *oldVersion
+	if version != 0 {
+		for _, ov := range v.versions {
+			if version <= ov.maxVersion {
+				o = ov
+				break
+			}
+		}
+	}
+
+	//最新版本
+	if o == nil {
+		msg := newMsg()
+		if err := decode(msg); err != nil {
+			return nil, err
+		}
+
+		//已弃用，计数
+		if v.deprecated && v.count.Add(1) == 1 {
+			log.Release("deprecated message %v in use", reflect.TypeOf(msg))
+		}
+
+		return msg, nil
+	}
+
+	//旧版本，解码后升级
+	old := o.newMsg()
+	if err := decode(old); err != nil {
+		return nil, err
+	}
+
+	//第一次使用时输出日志
+	if o.count.Add(1) == 1 {
+		log.Release("deprecated message %v in use (protocol version %v)", reflect.TypeOf(old), version)
+	}
+
+	return o.upgrade(old), nil
+}
+
+//使用统计，name为消息名
+func (v *MsgVersions) Stats(name string) []DeprecatedStat {
+	var stats []DeprecatedStat
+
+	//弃用的最新版本
+	if v.deprecated {
+		stats = append(stats, DeprecatedStat{Name: name, Count: v.count.Load()})
+	}
+
+	//旧版本
+	for _, o := range v.versions {
+		stats = append(stats, DeprecatedStat{Name: name, MaxVersion: o.maxVersion, Count: o.count.Load()})
+	}
+
+	return stats
+}
@@ -15,6 +15,7 @@ type WSClient struct {
 	ConnectInterval  time.Duration       //连接间隔
 	PendingWriteNum  int                 //发送缓冲区长度
 	MaxMsgLen        uint32              //最大消息长度
+	FrameMode        int                 //帧模式（FrameModeBinary、FrameModeText或FrameModeMirror）
 	HandshakeTimeout time.Duration       //握手超时时限
 	NewAgent         func(*WSConn) Agent //创建代理函数
 	dialer           websocket.Dialer    //拨号器
@@ -122,7 +123,7 @@ func (client *WSClient) connect() {
 	client.Unlock()
 
 	//创建一个ws连接
-	wsConn := newWSConn(conn, client.PendingWriteNum, client.MaxMsgLen)
+	wsConn := newWSConn(conn, client.PendingWriteNum, client.MaxMsgLen, client.FrameMode)
 	//创建代理
 	agent := client.NewAgent(wsConn)
 	//运行代理
@@ -2,44 +2,88 @@ package network
 
 import (
 	"errors"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"net"
+	"net/http"
 	"squash/log"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//ws帧类型
+const (
+	TextFrame   = websocket.TextMessage   //文本帧
+	BinaryFrame = websocket.BinaryMessage //二进制帧
+)
+
+//ws帧模式
+const (
+	FrameModeBinary = iota //只收发二进制帧（默认）
+	FrameModeText          //只收发文本帧
+	FrameModeMirror        //收发的帧类型与对方发来的第一个帧保持一致，之前默认发送二进制帧
 )
 
 //连接集合，值为空结构体
 type WebsocketConnSet map[*websocket.Conn]struct{}
 
+//待发送的ws帧
+type wsFrame struct {
+	frameType int      //帧类型
+	data      [][]byte //数据，每个元素作为一个ws消息发送
+}
+
 //ws连接
 type WSConn struct {
 	sync.Mutex                 //互斥锁
 	conn       *websocket.Conn //底层连接
-	writeChan  chan []byte     //发送缓冲
+	writeChan  chan *wsFrame   //发送缓冲
 	maxMsgLen  uint32          //最大消息长度
 	closeFlag  bool            //关闭标志
+	request    *http.Request   //升级请求（客户端连接为空）
+	frameMode  int             //帧模式
+	frameType  int32           //默认发送的帧类型（镜像模式下由读取goroutine设置，需原子操作）
+	mirrored   bool            //镜像模式下是否已经确定帧类型（只在读取goroutine中访问）
+	queueFull  bool            //是否因发送缓冲区已满被关闭
+	counter    connCounter     //连接计数器
 }
 
 //新建ws连接
-func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32) *WSConn {
+func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32, frameMode int) *WSConn {
 	//创建一个ws连接
 	wsConn := new(WSConn)
 	wsConn.conn = conn
-	wsConn.writeChan = make(chan []byte, pendingWriteNum)
+	wsConn.writeChan = make(chan *wsFrame, pendingWriteNum)
 	wsConn.maxMsgLen = maxMsgLen
+	wsConn.frameMode = frameMode
+
+	//文本模式默认发送文本帧，其他模式默认发送二进制帧
+	if frameMode == FrameModeText {
+		wsConn.frameType = TextFrame
+	} else {
+		wsConn.frameType = BinaryFrame
+	}
 
 	//在一个新的goroutine中发送数据
 	go func() {
 		//如果发送缓冲区被关闭，此循环会自动结束
 		//如果发送缓冲区没有数据，会阻塞在这里
-		for b := range wsConn.writeChan {
-			//收到的值为nil，而不是字节切片，中断循环
-			if b == nil {
+		for f := range wsConn.writeChan {
+			//收到的值为nil，而不是待发送的帧，中断循环
+			if f == nil {
 				break
 			}
 
 			//发送数据
-			err := conn.WriteMessage(websocket.BinaryMessage, b)
+			var err error
+			for _, b := range f.data {
+				if err = conn.WriteMessage(f.frameType, b); err != nil {
+					break
+				}
+				//记录发送的字节数
+				wsConn.counter.onWrite(len(b))
+			}
 
 			//发送失败
 			if err != nil {
@@ -74,27 +118,77 @@ func (wsConn *WSConn) doDestroy() {
 	wsConn.closeFlag = true
 }
 
-//写操作
-func (wsConn *WSConn) doWrite(b []byte) {
+//写操作，返回是否进入了发送缓冲区
+func (wsConn *WSConn) doWrite(f *wsFrame) bool {
 	//发送缓冲区长度等于最大容量，输出日志"管道已满"，做销毁操作
 	if len(wsConn.writeChan) == cap(wsConn.writeChan) {
 		log.Debug("close conn: channel full")
+		wsConn.queueFull = true
 		wsConn.doDestroy()
-		return
+		return false
 	}
 
 	//将待发数据发送到发送缓冲区
-	wsConn.writeChan <- b
+	wsConn.writeChan <- f
+	return true
 }
 
 //读取消息
 func (wsConn *WSConn) ReadMsg() ([]byte, error) {
-	_, b, err := wsConn.conn.ReadMessage()
-	return b, err
+	frameType, b, err := wsConn.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	//检查帧类型
+	if err := wsConn.checkFrameType(frameType); err != nil {
+		//通知对方不支持该类型的数据，由调用者关闭连接
+		wsConn.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseUnsupportedData, err.Error()),
+			time.Now().Add(time.Second))
+		return nil, err
+	}
+
+	//记录读取的消息
+	wsConn.counter.onRead(len(b))
+
+	return b, nil
 }
 
-//发送消息
+//检查读取到的帧类型是否符合帧模式
+func (wsConn *WSConn) checkFrameType(frameType int) error {
+	//镜像模式下的第一个帧，确定之后收发的帧类型
+	if wsConn.frameMode == FrameModeMirror && !wsConn.mirrored {
+		wsConn.mirrored = true
+		atomic.StoreInt32(&wsConn.frameType, int32(frameType))
+		return nil
+	}
+
+	//帧类型与当前帧类型不一致
+	if int32(frameType) != atomic.LoadInt32(&wsConn.frameType) {
+		return fmt.Errorf("unexpected frame type %v", frameType)
+	}
+
+	return nil
+}
+
+//发送消息，使用连接的默认帧类型
 func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
+	return wsConn.WriteFrame(0, args...)
+}
+
+//使用指定的帧类型发送消息，帧类型为0时使用连接的默认帧类型
+func (wsConn *WSConn) WriteFrame(frameType int, args ...[]byte) error {
+	//未指定帧类型，使用默认帧类型
+	if frameType == 0 {
+		frameType = int(atomic.LoadInt32(&wsConn.frameType))
+	}
+
+	//只能发送文本帧或者二进制帧
+	if frameType != TextFrame && frameType != BinaryFrame {
+		return fmt.Errorf("invalid frame type %v", frameType)
+	}
+
 	//加锁
 	wsConn.Lock()
 	//延迟解锁
@@ -120,7 +214,10 @@ func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
 
 	//只有一条消息
 	if len(args) == 1 {
-		wsConn.doWrite(args[0])
+		//进入发送缓冲区后记录发送的消息
+		if wsConn.doWrite(&wsFrame{frameType: frameType, data: [][]byte{args[0]}}) {
+			wsConn.counter.msgsWritten.Add(1)
+		}
 		return nil
 	}
 
@@ -133,12 +230,83 @@ func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
 		l += len(args[i])
 	}
 
-	//写操作
-	wsConn.doWrite(msg)
+	//写操作，进入发送缓冲区后记录发送的消息
+	if wsConn.doWrite(&wsFrame{frameType: frameType, data: [][]byte{msg}}) {
+		wsConn.counter.msgsWritten.Add(1)
+	}
 
 	return nil
 }
 
+//一次发送多条消息，多条消息作为一个整体进入发送缓冲区（用于分片）
+func (wsConn *WSConn) writeMsgs(frameType int, msgs [][]byte) error {
+	//未指定帧类型，使用默认帧类型
+	if frameType == 0 {
+		frameType = int(atomic.LoadInt32(&wsConn.frameType))
+	}
+
+	//只能发送文本帧或者二进制帧
+	if frameType != TextFrame && frameType != BinaryFrame {
+		return fmt.Errorf("invalid frame type %v", frameType)
+	}
+
+	//检查每条消息的长度
+	for _, m := range msgs {
+		if uint32(len(m)) > wsConn.maxMsgLen {
+			return errors.New("message too long")
+		} else if len(m) < 1 {
+			return errors.New("message too short")
+		}
+	}
+
+	//加锁
+	wsConn.Lock()
+	//延迟解锁
+	defer wsConn.Unlock()
+
+	//已经设置了关闭标志
+	if wsConn.closeFlag {
+		return nil
+	}
+
+	//写操作，进入发送缓冲区后记录发送的消息
+	if wsConn.doWrite(&wsFrame{frameType: frameType, data: msgs}) {
+		wsConn.counter.msgsWritten.Add(uint64(len(msgs)))
+	}
+
+	return nil
+}
+
+//返回单条消息的最大长度
+func (wsConn *WSConn) MaxMsgLen() uint32 {
+	return wsConn.maxMsgLen
+}
+
+//返回连接统计
+func (wsConn *WSConn) Stats() ConnStats {
+	s := wsConn.counter.stats()
+	s.WriteQueueLen = len(wsConn.writeChan)
+	s.WriteQueueCap = cap(wsConn.writeChan)
+	return s
+}
+
+//是否因发送缓冲区已满被关闭
+func (wsConn *WSConn) closedByQueueFull() bool {
+	wsConn.Lock()
+	defer wsConn.Unlock()
+	return wsConn.queueFull
+}
+
+//返回升级请求，可以读取请求头和查询参数（客户端连接返回nil）
+func (wsConn *WSConn) Request() *http.Request {
+	return wsConn.request
+}
+
+//返回协商的子协议
+func (wsConn *WSConn) Subprotocol() string {
+	return wsConn.conn.Subprotocol()
+}
+
 //返回本地地址
 func (wsConn *WSConn) LocalAddr() net.Addr {
 	return wsConn.conn.LocalAddr()
@@ -15,21 +15,35 @@ type WSServer struct {
 	MaxConnNum      int                 //最大连接数
 	PendingWriteNum int                 //发送缓冲区长度
 	MaxMsgLen       uint32              //最大消息长度
+	FrameMode       int                 //帧模式（FrameModeBinary、FrameModeText或FrameModeMirror）
 	HTTPTimeout     time.Duration       //http连接超时时限
 	NewAgent        func(*WSConn) Agent //创建代理函数
 	ln              net.Listener        //监听连接器
 	handler         *WSHandler          //调用的处理器
+
+	//升级检查
+	CheckOrigin  func(r *http.Request) bool         //检查请求来源，为空时允许所有来源
+	Authorize    func(r *http.Request) (int, error) //鉴权函数，返回错误时以返回的http状态码拒绝升级（状态码为0时使用403）
+	Subprotocols []string                           //服务器支持的子协议，按优先级排列
+
+	//http路由
+	Path        string       //ws连接的路径，为空时为"/"
+	HTTPHandler http.Handler //处理其他路径的http处理器，设置时Path不能为"/"
+	Ready       func() bool  //就绪检查函数，用于/readyz，为空时总是就绪
 }
 
 type WSHandler struct {
-	maxConnNum      int                 //最大连接数
-	pendingWriteNum int                 //发送缓冲区长度
-	maxMsgLen       uint32              //最大消息长度
-	newAgent        func(*WSConn) Agent //创建代理函数
-	upgrader        websocket.Upgrader  //升级器，将http连接升级为ws连接
-	conns           WebsocketConnSet    //连接集合
-	mutexConns      sync.Mutex          //互斥锁
-	wg              sync.WaitGroup      //等待组
+	maxConnNum      int                                //最大连接数
+	pendingWriteNum int                                //发送缓冲区长度
+	maxMsgLen       uint32                             //最大消息长度
+	frameMode       int                                //帧模式
+	newAgent        func(*WSConn) Agent                //创建代理函数
+	authorize       func(r *http.Request) (int, error) //鉴权函数
+	upgrader        websocket.Upgrader                 //升级器，将http连接升级为ws连接
+	conns           WebsocketConnSet                   //连接集合
+	mutexConns      sync.Mutex                         //互斥锁
+	wg              sync.WaitGroup                     //等待组
+	counter         serverCounter                      //服务器计数器
 }
 
 //运行http服务器
@@ -40,6 +54,20 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	//鉴权函数不为空，升级前进行鉴权
+	if handler.authorize != nil {
+		status, err := handler.authorize(r)
+		//鉴权失败，回复错误信息和状态码
+		if err != nil {
+			if status == 0 {
+				status = http.StatusForbidden
+			}
+			http.Error(w, http.StatusText(status), status)
+			log.Debug("authorize error: %v", err)
+			return
+		}
+	}
+
 	//升级http连接到ws协议
 	conn, err := handler.upgrader.Upgrade(w, r, nil)
 
@@ -70,6 +98,7 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		handler.mutexConns.Unlock()
 		conn.Close()
 		log.Debug("too many connections")
+		handler.counter.rejected.Add(1)
 		return
 	}
 
@@ -77,8 +106,12 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	handler.conns[conn] = struct{}{}
 	//解锁
 	handler.mutexConns.Unlock()
+	//记录接受的连接
+	handler.counter.accepted.Add(1)
 	//创建一个ws连接
-	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen)
+	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen, handler.frameMode)
+	//保存升级请求，代理可以从中读取请求头和查询参数
+	wsConn.request = r
 	//创建代理
 	agent := handler.newAgent(wsConn)
 	//在一个新的goroutine中运行代理，一个客户端一个agent
@@ -87,6 +120,10 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	/*清理工作开始*/
 	//关闭连接
 	wsConn.Close()
+	//记录因发送缓冲区已满被关闭的连接
+	if wsConn.closedByQueueFull() {
+		handler.counter.queueFullClosed.Add(1)
+	}
 	//加锁
 	handler.mutexConns.Lock()
 	//从连接集合中删除连接
@@ -137,6 +174,22 @@ func (server *WSServer) Start() {
 		log.Fatal("NewAgent must not be nil")
 	}
 
+	//ws连接路径为空，重置到"/"
+	if server.Path == "" {
+		server.Path = "/"
+	}
+
+	//设置了其他路径的http处理器，ws连接不能占用根路径
+	if server.HTTPHandler != nil && server.Path == "/" {
+		log.Fatal("Path must not be / when HTTPHandler is set")
+	}
+
+	//来源检查函数为空，允许所有来源
+	checkOrigin := server.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = func(_ *http.Request) bool { return true }
+	}
+
 	//保存监听连接器
 	server.ln = ln
 
@@ -145,18 +198,30 @@ func (server *WSServer) Start() {
 		maxConnNum:      server.MaxConnNum,      //最大连接数
 		pendingWriteNum: server.PendingWriteNum, //发送缓冲区长度
 		maxMsgLen:       server.MaxMsgLen,       //最大消息长度
+		frameMode:       server.FrameMode,       //帧模式
 		newAgent:        server.NewAgent,        //创建代理函数
+		authorize:       server.Authorize,       //鉴权函数
 		conns:           make(WebsocketConnSet), //连接集合
 		upgrader: websocket.Upgrader{ //升级器，将http连接升级为ws连接
 			HandshakeTimeout: server.HTTPTimeout,
-			CheckOrigin:      func(_ *http.Request) bool { return true },
+			CheckOrigin:      checkOrigin,
+			Subprotocols:     server.Subprotocols,
 		},
 	}
 
+	//设置路由：健康检查、就绪检查、ws连接以及其他路径
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", server.serveHealthz)
+	mux.HandleFunc("/readyz", server.serveReadyz)
+	mux.Handle(server.Path, server.handler)
+	if server.HTTPHandler != nil {
+		mux.Handle("/", server.HTTPHandler)
+	}
+
 	//设置http服务器
 	httpServer := &http.Server{
 		Addr:           server.Addr,        //监听的TCP地址
-		Handler:        server.handler,     //调用的处理器
+		Handler:        mux,                //调用的处理器
 		ReadTimeout:    server.HTTPTimeout, //读取操作超时时限
 		WriteTimeout:   server.HTTPTimeout, //写入操作超时时限
 		MaxHeaderBytes: 1024,               //请求头最大长度
@@ -166,6 +231,21 @@ func (server *WSServer) Start() {
 	go httpServer.Serve(ln)
 }
 
+//健康检查，进程存活即返回200
+func (server *WSServer) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+//就绪检查，就绪返回200，否则返回503
+func (server *WSServer) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	if server.Ready != nil && !server.Ready() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Write([]byte("ok"))
+}
+
 //关闭ws服务器
 func (server *WSServer) Close() {
 	//关闭监听器（会导致再Accept时出错）
@@ -185,3 +265,17 @@ func (server *WSServer) Close() {
 	//等待所有连接的goroutine退出
 	server.handler.wg.Wait()
 }
+
+//返回服务器统计
+func (server *WSServer) Stats() ServerStats {
+	//还未启动
+	if server.handler == nil {
+		return ServerStats{}
+	}
+
+	server.handler.mutexConns.Lock()
+	connNum := len(server.handler.conns)
+	server.handler.mutexConns.Unlock()
+
+	return server.handler.counter.stats(connNum)
+}
@@ -0,0 +1,178 @@
+package timer
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+//时钟，提供当前时间和定时器后端
+type Clock interface {
+	Now() time.Time //当前时间
+	Backend
+}
+
+//系统时钟，使用time.Now和runtime定时器
+var RealClock Clock = realClock{}
+
+//系统时钟
+type realClock struct{}
+
+//实现Clock接口的Now方法
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+//实现Backend接口的AfterFunc方法
+func (realClock) AfterFunc(d time.Duration, f func()) Stopper {
+	return time.AfterFunc(d, f)
+}
+
+//使用系统时间和指定后端的时钟
+type backendClock struct {
+	Backend
+}
+
+//实现Clock接口的Now方法
+func (backendClock) Now() time.Time {
+	return time.Now()
+}
+
+//手动推进的时钟，用于测试
+//Advance或Set推进时间时，按到期时间顺序在调用者的goroutine中触发到期的定时器
+//分发器使用FakeClock时，定时器被发送到ChanTimer，ChanTimer需要足够长或者由其他goroutine读取
+//计划任务在回调执行时才注册下一次定时器，测试跨越多次触发时可以配合Next逐次推进
+type FakeClock struct {
+	mutex  sync.Mutex //互斥锁
+	now    time.Time  //当前时间
+	seq    uint64     //定时器序号，到期时间相同时按注册顺序触发
+	timers fakeTimers //未到期的定时器（最小堆）
+}
+
+//手动推进的时钟上的定时器
+type fakeTimer struct {
+	c     *FakeClock //所属时钟
+	when  time.Time  //到期时间
+	seq   uint64     //注册序号
+	f     func()     //到期时调用的函数
+	index int        //在堆中的下标，不在堆中时为-1
+}
+
+//创建手动推进的时钟，now为初始时间
+func NewFakeClock(now time.Time) *FakeClock {
+	c := new(FakeClock)
+	c.now = now
+	return c
+}
+
+//实现Clock接口的Now方法
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+//实现Backend接口的AfterFunc方法
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Stopper {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.seq++
+	t := &fakeTimer{c: c, when: c.now.Add(d), seq: c.seq, f: f}
+	heap.Push(&c.timers, t)
+
+	return t
+}
+
+//将时间推进d，并触发到期的定时器
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+//将时间设置为t，并按顺序触发t之前到期的定时器（t早于当前时间时不会回拨时间）
+//触发每个定时器时，当前时间为该定时器的到期时间
+func (c *FakeClock) Set(t time.Time) {
+	c.mutex.Lock()
+
+	for len(c.timers) > 0 && !c.timers[0].when.After(t) {
+		ft := heap.Pop(&c.timers).(*fakeTimer)
+		if ft.when.After(c.now) {
+			c.now = ft.when
+		}
+
+		//在锁外调用，f中可以注册或停止定时器
+		c.mutex.Unlock()
+		ft.f()
+		c.mutex.Lock()
+	}
+
+	if t.After(c.now) {
+		c.now = t
+	}
+
+	c.mutex.Unlock()
+}
+
+//获取下一个定时器的到期时间，没有定时器时ok为false
+func (c *FakeClock) Next() (t time.Time, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.timers) == 0 {
+		return
+	}
+
+	return c.timers[0].when, true
+}
+
+//停止定时器，定时器还未到期时返回true
+func (t *fakeTimer) Stop() bool {
+	t.c.mutex.Lock()
+	defer t.c.mutex.Unlock()
+
+	//已到期或已停止
+	if t.index < 0 {
+		return false
+	}
+
+	heap.Remove(&t.c.timers, t.index)
+	return true
+}
+
+//定时器最小堆，实现heap.Interface接口
+type fakeTimers []*fakeTimer
+
+func (h fakeTimers) Len() int {
+	return len(h)
+}
+
+func (h fakeTimers) Less(i, j int) bool {
+	if h[i].when.Equal(h[j].when) {
+		return h[i].seq < h[j].seq
+	}
+
+	return h[i].when.Before(h[j].when)
+}
+
+func (h fakeTimers) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *fakeTimers) Push(x interface{}) {
+	t := x.(*fakeTimer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *fakeTimers) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
@@ -8,14 +8,25 @@ import (
 	"time"
 )
 
-// Field name   | Mandatory? | Allowed values | Allowed special characters
-// ----------   | ---------- | -------------- | --------------------------
-// Seconds      | No         | 0-59           | * / , -
-// Minutes      | Yes        | 0-59           | * / , -
-// Hours        | Yes        | 0-23           | * / , -
-// Day of month | Yes        | 1-31           | * / , -
-// Month        | Yes        | 1-12           | * / , -
-// Day of week  | Yes        | 0-6            | * / , -
+// Field name   | Mandatory? | Allowed values  | Allowed special characters
+// ----------   | ---------- | --------------  | --------------------------
+// Seconds      | No         | 0-59            | * / , -
+// Minutes      | Yes        | 0-59            | * / , -
+// Hours        | Yes        | 0-23            | * / , -
+// Day of month | Yes        | 1-31            | * / , - ? L W
+// Month        | Yes        | 1-12 or JAN-DEC | * / , -
+// Day of week  | Yes        | 0-7 or SUN-SAT  | * / , - ? L #
+//
+//表达式前可以用TZ=或CRON_TZ=指定时区，如：CRON_TZ=Asia/Shanghai 0 0 5 * * *
+//宏：@yearly（@annually）、@monthly、@weekly、@daily（@midnight）、@hourly、@every <duration>（如@every 5m）
+//日期修饰符：
+//L：每月最后一天；LW：每月最后一个工作日；nW：离n日最近的工作日（不跨月）
+//nL：每月最后一个星期n；n#k：每月第k个星期n
+//星期中的7也表示星期天
+//
+//夏令时：
+//拨快时被跳过的时间在切换时触发；
+//回拨时重复的时间只在第一次出现时触发，小时字段为*时两次都触发
 
 //cron表达式
 type CronExpr struct {
@@ -25,16 +36,114 @@ type CronExpr struct {
 	dom   uint64
 	month uint64
 	dow   uint64
+
+	loc   *time.Location //时区，为空时使用传入时间的时区
+	every time.Duration  //@every的间隔，大于0时按固定间隔计算
+
+	domLast        bool     //L：每月最后一天
+	domLastWeekday bool     //LW：每月最后一个工作日
+	domWeekday     uint64   //nW：离n日最近的工作日
+	dowLast        uint64   //nL：每月最后一个星期n
+	dowNth         [7]uint8 //n#k：每月第k个星期n（第k位）
 }
 
-//创建cron表达式
+//所有小时都设置了的标志位
+const cronHourStar = 1<<24 - 1
+
+//查找下一次时间的最大年数
+const cronMaxYears = 8
+
+//月份名字
+var cronMonthNames = map[string]int{
+	"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+	"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+}
+
+//星期名字
+var cronDowNames = map[string]int{
+	"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+}
+
+//宏
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+//创建cron表达式，时区为表达式中TZ=指定的时区，没有指定时使用传入Next的时间的时区
 func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
+	return NewCronExprIn(expr, nil)
+}
+
+//创建指定时区的cron表达式，表达式中TZ=指定的时区优先，loc为空时使用传入Next的时间的时区
+func NewCronExprIn(expr string, loc *time.Location) (cronExpr *CronExpr, err error) {
 	//用空格分割表达式
 	fields := strings.Fields(expr)
 
+	//时区前缀
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "TZ=") || strings.HasPrefix(fields[0], "CRON_TZ=")) {
+		loc, err = time.LoadLocation(fields[0][strings.Index(fields[0], "=")+1:])
+		if err != nil {
+			err = fmt.Errorf("invalid expr %v: %v", expr, err)
+			return
+		}
+
+		fields = fields[1:]
+	}
+
+	//解析
+	cronExpr, err = parseCronExpr(fields)
+	if err != nil {
+		err = fmt.Errorf("invalid expr %v: %v", expr, err)
+		return
+	}
+
+	//保存时区
+	cronExpr.loc = loc
+	return
+}
+
+//解析cron表达式的字段
+func parseCronExpr(fields []string) (cronExpr *CronExpr, err error) {
+	//宏
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		//固定间隔
+		if fields[0] == "@every" {
+			if len(fields) != 2 {
+				err = fmt.Errorf("expected @every <duration>")
+				return
+			}
+
+			//间隔不能小于1秒
+			var every time.Duration
+			every, err = time.ParseDuration(fields[1])
+			if err != nil || every < time.Second {
+				err = fmt.Errorf("invalid duration: %v", fields[1])
+				return
+			}
+
+			cronExpr = new(CronExpr)
+			cronExpr.every = every
+			return
+		}
+
+		//其他宏展开为表达式
+		macro, ok := cronMacros[strings.ToLower(fields[0])]
+		if !ok || len(fields) != 1 {
+			err = fmt.Errorf("unknown macro: %v", strings.Join(fields, " "))
+			return
+		}
+		fields = strings.Fields(macro)
+	}
+
 	//数组长度为5或者6（Seconds不是强制设置的）
 	if len(fields) != 5 && len(fields) != 6 {
-		err = fmt.Errorf("invalid expr %v: expected 5 or 6 fields, got %v", expr, len(fields))
+		err = fmt.Errorf("expected 5 or 6 fields, got %v", len(fields))
 		return
 	}
 
@@ -48,55 +157,146 @@ func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
 
 	/*解析字段开始*/
 	//Seconds
-	cronExpr.sec, err = parseCronField(fields[0], 0, 59)
+	cronExpr.sec, err = parseCronField(fields[0], 0, 59, nil)
 	if err != nil {
-		goto onError
+		return
 	}
 
 	//Minutes
-	cronExpr.min, err = parseCronField(fields[1], 0, 59)
+	cronExpr.min, err = parseCronField(fields[1], 0, 59, nil)
 	if err != nil {
-		goto onError
+		return
 	}
 
 	//Hours
-	cronExpr.hour, err = parseCronField(fields[2], 0, 23)
+	cronExpr.hour, err = parseCronField(fields[2], 0, 23, nil)
 	if err != nil {
-		goto onError
+		return
 	}
 
 	//Day of month
-	cronExpr.dom, err = parseCronField(fields[3], 1, 31)
+	err = cronExpr.parseDom(fields[3])
 	if err != nil {
-		goto onError
+		return
 	}
 
 	//Month
-	cronExpr.month, err = parseCronField(fields[4], 1, 12)
+	cronExpr.month, err = parseCronField(fields[4], 1, 12, cronMonthNames)
 	if err != nil {
-		goto onError
+		return
 	}
 
 	//Day of week
-	cronExpr.dow, err = parseCronField(fields[5], 0, 6)
-	if err != nil {
-		goto onError
-	}
+	err = cronExpr.parseDow(fields[5])
 	/*解析字段结束*/
 
 	return
+}
+
+//解析day-of-month字段，?等同于*，L、LW和nW单独记录
+func (e *CronExpr) parseDom(field string) (err error) {
+	var rest []string
+
+	for _, f := range strings.Split(field, ",") {
+		switch {
+		case f == "?":
+			rest = append(rest, "*")
+		case f == "L":
+			e.domLast = true
+		case f == "LW":
+			e.domLastWeekday = true
+		case strings.HasSuffix(f, "W"):
+			//离n日最近的工作日
+			day, err := strconv.Atoi(f[:len(f)-1])
+			if err != nil || day < 1 || day > 31 {
+				return fmt.Errorf("invalid day: %v", f)
+			}
+			e.domWeekday |= 1 << uint(day)
+		default:
+			rest = append(rest, f)
+		}
+	}
+
+	//其他部分按普通字段解析
+	if len(rest) > 0 {
+		e.dom, err = parseCronField(strings.Join(rest, ","), 1, 31, nil)
+	}
 
-onError:
-	err = fmt.Errorf("invalid expr %v: %v", expr, err)
 	return
 }
 
+//解析day-of-week字段，?等同于*，nL和n#k单独记录
+func (e *CronExpr) parseDow(field string) (err error) {
+	var rest []string
+
+	for _, f := range strings.Split(field, ",") {
+		switch {
+		case f == "?":
+			rest = append(rest, "*")
+		case strings.Contains(f, "#"):
+			//每月第k个星期n
+			nk := strings.Split(f, "#")
+			if len(nk) != 2 {
+				return fmt.Errorf("invalid weekday: %v", f)
+			}
+			dow, err := parseCronDow(nk[0])
+			if err != nil {
+				return err
+			}
+			k, err := strconv.Atoi(nk[1])
+			if err != nil || k < 1 || k > 5 {
+				return fmt.Errorf("invalid weekday: %v", f)
+			}
+			e.dowNth[dow] |= 1 << uint(k)
+		case len(f) > 1 && strings.HasSuffix(f, "L"):
+			//每月最后一个星期n
+			dow, err := parseCronDow(f[:len(f)-1])
+			if err != nil {
+				return err
+			}
+			e.dowLast |= 1 << uint(dow)
+		default:
+			rest = append(rest, f)
+		}
+	}
+
+	//其他部分按普通字段解析
+	if len(rest) > 0 {
+		e.dow, err = parseCronField(strings.Join(rest, ","), 0, 7, cronDowNames)
+		//7也表示星期天
+		if e.dow&(1<<7) != 0 {
+			e.dow = e.dow&^(1<<7) | 1
+		}
+	}
+
+	return
+}
+
+//解析星期（数字或名字），7转换为0
+func parseCronDow(s string) (int, error) {
+	dow, err := parseCronValue(s, cronDowNames)
+	if err != nil || dow < 0 || dow > 7 {
+		return 0, fmt.Errorf("invalid weekday: %v", s)
+	}
+
+	return dow % 7, nil
+}
+
+//解析数字或名字（不区分大小写）
+func parseCronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+
+	return strconv.Atoi(s)
+}
+
 //解析cron字段
-func parseCronField(field string, min int, max int) (cronField uint64, err error) {
+func parseCronField(field string, min int, max int, names map[string]int) (cronField uint64, err error) {
 	//用逗号分割字段
 	fields := strings.Split(field, ",")
 
-	// 6种形式：
+	// 6种形式（num也可以是名字）：
 	// 1. *
 	// 2. num
 	// 3. num-num
@@ -134,7 +334,7 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 			end = max
 		} else {
 			//起始值转化为整数
-			start, err = strconv.Atoi(startAndEnd[0])
+			start, err = parseCronValue(startAndEnd[0], names)
 			//转化失败
 			if err != nil {
 				err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
@@ -149,7 +349,7 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 				}
 			} else { //形式3或6
 				//结束值转化为整数
-				end, err = strconv.Atoi(startAndEnd[1])
+				end, err = parseCronValue(startAndEnd[1], names)
 				//转化失败
 				if err != nil {
 					err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
@@ -231,33 +431,188 @@ func (e *CronExpr) matchDay(t time.Time) bool {
 	//day-of-month标志位（1-31）都设置了
 	//1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1110
 	if e.dom == 0xfffffffe {
-		return 1<<uint(t.Weekday())&e.dow != 0
+		return e.matchDow(t)
 	}
 
 	//day-of-week标志位（0-6）都设置了
 	//1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111
 	if e.dow == 0x7f {
-		return 1<<uint(t.Day())&e.dom != 0
+		return e.matchDom(t)
 	}
 
 	//不确定哪个能够匹配到
-	return 1<<uint(t.Weekday())&e.dow != 0 || 1<<uint(t.Day())&e.dom != 0
+	return e.matchDow(t) || e.matchDom(t)
+}
+
+//匹配day-of-month（包括L、LW和nW）
+func (e *CronExpr) matchDom(t time.Time) bool {
+	day := t.Day()
+	if 1<<uint(day)&e.dom != 0 {
+		return true
+	}
+
+	//当月天数
+	last := daysIn(t)
+
+	//L：最后一天
+	if e.domLast && day == last {
+		return true
+	}
+
+	//LW：最后一个工作日
+	if e.domLastWeekday && day == nearestWeekday(t, last, last) {
+		return true
+	}
+
+	//nW：离n日最近的工作日
+	if e.domWeekday != 0 {
+		for n := 1; n <= 31; n++ {
+			if 1<<uint(n)&e.domWeekday != 0 && day == nearestWeekday(t, n, last) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//匹配day-of-week（包括nL和n#k）
+func (e *CronExpr) matchDow(t time.Time) bool {
+	dow := t.Weekday()
+	if 1<<uint(dow)&e.dow != 0 {
+		return true
+	}
+
+	//nL：最后一个星期n（之后7天内跨月）
+	if 1<<uint(dow)&e.dowLast != 0 && t.Day()+7 > daysIn(t) {
+		return true
+	}
+
+	//n#k：第k个星期n
+	return e.dowNth[dow]&(1<<uint((t.Day()-1)/7+1)) != 0
+}
+
+//当月天数
+func daysIn(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+//当月离n日最近的工作日（不跨月），n超过当月天数时按最后一天计算
+func nearestWeekday(t time.Time, n int, last int) int {
+	if n > last {
+		n = last
+	}
+
+	switch time.Date(t.Year(), t.Month(), n, 0, 0, 0, 0, time.UTC).Weekday() {
+	case time.Saturday:
+		//1日为星期六时取3日（星期一）
+		if n == 1 {
+			return 3
+		}
+		return n - 1
+	case time.Sunday:
+		//最后一天为星期天时取前一个星期五
+		if n == last {
+			return n - 2
+		}
+		return n + 1
+	}
+
+	return n
+}
+
+//将时刻按时区偏移转换为墙上时间（用UTC时间表示，不受夏令时影响）
+func wallClock(t time.Time, offset int) time.Time {
+	return t.UTC().Add(time.Duration(offset) * time.Second)
 }
 
 //计算下一次时间
 func (e *CronExpr) Next(t time.Time) time.Time {
-	//计算下一秒时间
-	t = t.Truncate(time.Second).Add(time.Second)
-	//保存当前年份
-	year := t.Year()
+	//使用表达式的时区
+	loc := e.loc
+	if loc == nil {
+		loc = t.Location()
+	}
+	t = t.In(loc)
+
+	//固定间隔
+	if e.every > 0 {
+		return t.Add(e.every - time.Duration(t.Nanosecond()))
+	}
+
+	//最大年份
+	maxYear := t.Year() + cronMaxYears
+
+	//按时区时段（偏移不变的时间段）查找，时段内墙上时间和时刻一一对应
+	cur := t
+	_, offset := cur.Zone()
+	//查找的起始墙上时间（包含），为下一秒
+	from := wallClock(t.Truncate(time.Second), offset).Add(time.Second)
+
+	for {
+		//当前时段的开始和结束时刻，为零值时表示没有边界
+		start, end := cur.ZoneBounds()
+		_, offset = cur.Zone()
+
+		//当前时段结束时的墙上时间（不包含）
+		var limit time.Time
+		if !end.IsZero() {
+			limit = wallClock(end, offset)
+		}
+
+		//在当前时段内查找
+		if w, ok := e.next(from, limit, maxYear); ok {
+			//回拨时重复的时间，小时字段不为*时只在第一次出现时触发
+			if e.hour != cronHourStar && repeated(w, start, offset, loc) {
+				from = w.Add(time.Second)
+				continue
+			}
+
+			return w.Add(-time.Duration(offset) * time.Second).In(loc)
+		}
+
+		//没有下一个时段
+		if end.IsZero() || end.Year() > maxYear {
+			return time.Time{}
+		}
+
+		//下一个时段
+		cur = end.In(loc)
+		_, nextOffset := cur.Zone()
+
+		//拨快时跳过的时间，在切换时触发
+		if nextOffset > offset {
+			if _, ok := e.next(limit, wallClock(end, nextOffset), maxYear); ok {
+				return cur
+			}
+		}
+
+		from = wallClock(end, nextOffset)
+	}
+}
+
+//墙上时间w是否为回拨后重复的时间（即在上一个时段已经出现过）
+func repeated(w time.Time, start time.Time, offset int, loc *time.Location) bool {
+	//没有上一个时段
+	if start.IsZero() {
+		return false
+	}
+
+	//上一个时段的偏移更大（回拨），并且w在上一个时段结束时的墙上时间之前
+	_, prevOffset := start.Add(-time.Second).In(loc).Zone()
+	return prevOffset > offset && w.Before(wallClock(start, prevOffset))
+}
+
+//从墙上时间t（包含）开始查找下一个匹配的墙上时间，limit不为零值时只查找limit之前的时间
+//墙上时间用UTC时间表示，因此按天、小时截断都是准确的
+func (e *CronExpr) next(t time.Time, limit time.Time, maxYear int) (time.Time, bool) {
 	//标志是否已初始化（新建一个时间就是初始化）
 	initFlag := false
 
 retry:
-	//Year
-	//跨年，返回零值
-	if t.Year() > year+1 {
-		return time.Time{}
+	//超出查找范围
+	if t.Year() > maxYear || !limit.IsZero() && !t.Before(limit) {
+		return time.Time{}, false
 	}
 
 	//Month
@@ -265,7 +620,7 @@ retry:
 		//没有初始化，标志为已初始化，新建一个时间
 		if !initFlag {
 			initFlag = true
-			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
 		}
 
 		//加一个月
@@ -280,7 +635,7 @@ retry:
 	for !e.matchDay(t) {
 		if !initFlag {
 			initFlag = true
-			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
 		}
 
 		t = t.AddDate(0, 0, 1)
@@ -317,7 +672,7 @@ retry:
 
 	//Seconds
 	for 1<<uint(t.Second())&e.sec == 0 {
-		//程序开头已经截断到秒了
+		//起始时间已经截断到秒了
 		if !initFlag {
 			initFlag = true
 		}
@@ -328,5 +683,10 @@ retry:
 		}
 	}
 
-	return t
+	//超出查找范围
+	if !limit.IsZero() && !t.Before(limit) {
+		return time.Time{}, false
+	}
+
+	return t, true
 }
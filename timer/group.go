@@ -0,0 +1,136 @@
+package timer
+
+import (
+	"squash/log"
+	"time"
+)
+
+//定时器组，记录通过组创建的定时器和计划任务，可以一次全部停止
+//例如每个玩家或房间一个组，玩家断开或房间解散时调用Close，避免回调作用在已销毁的状态上
+//定时器组的方法应在分发器所属模块的goroutine中调用
+type Group struct {
+	disp   *Dispatcher         //分发器
+	name   string              //组名，用于泄漏日志
+	timers map[*Timer]struct{} //未到期的定时器
+	crons  map[*Cron]struct{}  //未停止的计划任务
+	closed bool                //是否已关闭
+}
+
+//创建定时器组，name用于泄漏日志（如"player 1001"）
+//分发器的CloseGroups被调用时（模块销毁时），未关闭的组中还有未到期的定时器或计划任务会输出泄漏日志
+//分发器只记录非空的组，组中的定时器和计划任务全部到期或停止后（如调用StopAll）不再被分发器引用，不调用Close也不会泄漏
+func (disp *Dispatcher) NewGroup(name string) *Group {
+	group := new(Group)
+	group.disp = disp
+	group.name = name
+	group.timers = make(map[*Timer]struct{})
+	group.crons = make(map[*Cron]struct{})
+	return group
+}
+
+//注册定时器
+func (group *Group) AfterFunc(d time.Duration, cb func()) *Timer {
+	t := group.disp.AfterFunc(d, cb)
+	group.own(t)
+	return t
+}
+
+//注册周期定时器
+func (group *Group) TickFunc(d time.Duration, cb func()) *Timer {
+	t := group.disp.TickFunc(d, cb)
+	group.own(t)
+	return t
+}
+
+//注册计划任务
+func (group *Group) CronFunc(expr string, cb func()) (*Cron, error) {
+	cron, err := group.disp.CronFunc(expr, cb)
+	if err != nil {
+		return nil, err
+	}
+
+	//组已关闭，立即停止
+	if group.closed {
+		cron.Stop()
+		return cron, nil
+	}
+
+	cron.group = group
+	group.crons[cron] = struct{}{}
+	group.disp.groups[group] = struct{}{}
+	return cron, nil
+}
+
+//将定时器加入组，组已关闭时立即停止定时器
+func (group *Group) own(t *Timer) {
+	if group.closed {
+		t.Stop()
+		return
+	}
+
+	t.group = group
+	group.add(t)
+}
+
+//添加定时器（定时器重置时也会调用）
+func (group *Group) add(t *Timer) {
+	//组已关闭，重置的定时器立即停止
+	if group.closed {
+		t.group = nil
+		t.Stop()
+		return
+	}
+
+	group.timers[t] = struct{}{}
+	group.disp.groups[group] = struct{}{}
+}
+
+//删除定时器
+func (group *Group) remove(t *Timer) {
+	delete(group.timers, t)
+	group.untrack()
+}
+
+//删除计划任务
+func (group *Group) removeCron(c *Cron) {
+	delete(group.crons, c)
+	group.untrack()
+}
+
+//组为空时从分发器中删除
+func (group *Group) untrack() {
+	if group.Len() == 0 {
+		delete(group.disp.groups, group)
+	}
+}
+
+//未到期的定时器和未停止的计划任务数量
+func (group *Group) Len() int {
+	return len(group.timers) + len(group.crons)
+}
+
+//停止组中所有的定时器和计划任务，组可以继续使用
+func (group *Group) StopAll() {
+	for t := range group.timers {
+		t.Stop()
+	}
+	for c := range group.crons {
+		c.Stop()
+	}
+}
+
+//停止组中所有的定时器和计划任务并关闭组，之后通过组创建的定时器会立即停止
+func (group *Group) Close() {
+	group.StopAll()
+	group.closed = true
+	delete(group.disp.groups, group)
+}
+
+//检查泄漏并关闭组
+func (group *Group) leak() {
+	if group.Len() > 0 {
+		log.Error("timer group %v leaked: %v timers and %v crons still pending", group.name, len(group.timers), len(group.crons))
+	}
+
+	group.Close()
+}
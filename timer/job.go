@@ -0,0 +1,430 @@
+package timer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"squash/conf"
+	"squash/log"
+	"sync"
+	"time"
+)
+
+//每个任务启动时最多补运行的次数，避免长时间停机后补运行过多
+const maxCatchUp = 1024
+
+//每个任务启动时最多逐个统计的错过的运行次数，超过后直接查找最后一次错过的运行
+const maxMissed = 1 << 16
+
+//错过运行的补运行策略
+type CatchUpPolicy int
+
+const (
+	CatchUpOnce CatchUpPolicy = iota //错过的运行只补最后一次
+	CatchUpAll                       //错过的运行全部补上（最多maxCatchUp次）
+	CatchUpSkip                      //不补运行
+)
+
+//策略名
+func (p CatchUpPolicy) String() string {
+	switch p {
+	case CatchUpOnce:
+		return "once"
+	case CatchUpAll:
+		return "all"
+	case CatchUpSkip:
+		return "skip"
+	}
+
+	return fmt.Sprintf("CatchUpPolicy(%d)", int(p))
+}
+
+//任务存储，保存每个任务最后一次运行的计划时间
+//多个模块的调度器可以共享同一个存储，实现需要支持并发调用
+type JobStore interface {
+	Load(name string) (lastRun time.Time, ok bool, err error) //读取任务最后一次运行的计划时间，没有记录时ok为false
+	Save(name string, lastRun time.Time) error                //保存任务最后一次运行的计划时间
+}
+
+//文件任务存储，所有任务的记录保存在一个json文件中
+//每次保存写入临时文件后重命名，写入过程中停机不会损坏已有记录
+type FileJobStore struct {
+	mutex  sync.Mutex           //互斥锁
+	path   string               //文件路径
+	runs   map[string]time.Time //任务名->最后一次运行的计划时间
+	loaded bool                 //是否已读取文件
+}
+
+//创建文件任务存储，文件不存在时在第一次保存时创建
+func NewFileJobStore(path string) *FileJobStore {
+	store := new(FileJobStore)
+	store.path = path
+	return store
+}
+
+//读取文件（需要持有锁）
+func (store *FileJobStore) load() error {
+	if store.loaded {
+		return nil
+	}
+
+	runs := make(map[string]time.Time)
+	data, err := os.ReadFile(store.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &runs); err != nil {
+			return fmt.Errorf("job store %v: %v", store.path, err)
+		}
+	}
+
+	store.runs = runs
+	store.loaded = true
+	return nil
+}
+
+//实现JobStore接口的Load方法
+func (store *FileJobStore) Load(name string) (time.Time, bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.load(); err != nil {
+		return time.Time{}, false, err
+	}
+
+	lastRun, ok := store.runs[name]
+	return lastRun, ok, nil
+}
+
+//实现JobStore接口的Save方法
+func (store *FileJobStore) Save(name string, lastRun time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.load(); err != nil {
+		return err
+	}
+
+	store.runs[name] = lastRun
+	data, err := json.MarshalIndent(store.runs, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	//写入同目录下的临时文件再重命名
+	f, err := os.CreateTemp(filepath.Dir(store.path), filepath.Base(store.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), store.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+//任务调度器，在cron计划任务的基础上持久化每个任务最后一次运行的计划时间
+//注册任务时检测停机期间错过的运行，并按补运行策略运行
+//调度器的方法和任务的回调都在分发器所属模块的goroutine中调用
+type Scheduler struct {
+	disp  *Dispatcher     //分发器
+	store JobStore        //任务存储，为空时不持久化
+	jobs  map[string]*Job //任务名->任务
+}
+
+//任务
+type Job struct {
+	s        *Scheduler    //所属调度器
+	name     string        //任务名
+	expr     string        //cron表达式
+	cronExpr *CronExpr     //解析后的cron表达式
+	policy   CatchUpPolicy //补运行策略
+	cb       func()        //回调
+	t        *Timer        //下一次运行的定时器
+	lastRun  time.Time     //最后一次运行的计划时间
+	nextRun  time.Time     //下一次运行的计划时间
+	runs     uint64        //本次启动后的运行次数
+	missed   int           //启动时检测到的错过的运行次数（最多统计maxMissed次）
+	caughtUp int           //启动时补运行的次数
+	lastErr  string        //最后一次运行或保存的错误
+	stopped  bool          //是否已停止
+}
+
+//任务状态
+type Status struct {
+	Name     string        //任务名
+	Expr     string        //cron表达式
+	Policy   CatchUpPolicy //补运行策略
+	LastRun  time.Time     //最后一次运行的计划时间，为零值时表示从未运行
+	NextRun  time.Time     //下一次运行的计划时间，为零值时表示不再运行
+	Runs     uint64        //本次启动后的运行次数（包括补运行）
+	Missed   int           //启动时检测到的错过的运行次数（最多统计maxMissed次）
+	CaughtUp int           //启动时补运行的次数
+	LastErr  string        //最后一次运行或保存的错误
+}
+
+//创建任务调度器，store为空时不持久化（每次启动都视为第一次注册，不检测错过的运行）
+func NewScheduler(disp *Dispatcher, store JobStore) *Scheduler {
+	s := new(Scheduler)
+	s.disp = disp
+	s.store = store
+	s.jobs = make(map[string]*Job)
+	return s
+}
+
+//注册任务，name在共享同一个存储的所有调度器中唯一
+//任务第一次注册时从当前时间开始计划；之后注册时，最后一次运行到当前时间之间错过的运行按policy补运行
+func (s *Scheduler) JobFunc(name string, expr string, policy CatchUpPolicy, cb func()) (*Job, error) {
+	//任务名不能重复
+	if _, ok := s.jobs[name]; ok {
+		return nil, fmt.Errorf("job %v already registered", name)
+	}
+
+	//解析cron表达式
+	cronExpr, err := NewCronExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	//读取最后一次运行的计划时间
+	var lastRun time.Time
+	var ok bool
+	if s.store != nil {
+		lastRun, ok, err = s.store.Load(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	j := new(Job)
+	j.s = s
+	j.name = name
+	j.expr = expr
+	j.cronExpr = cronExpr
+	j.policy = policy
+	j.cb = cb
+
+	now := s.disp.Now()
+	if ok {
+		j.lastRun = lastRun
+
+		//统计错过的运行，补运行的列表最多保存maxCatchUp次，latest为最后一次错过的运行
+		var missed []time.Time
+		var latest time.Time
+		t := cronExpr.Next(lastRun)
+		for ; !t.IsZero() && !t.After(now) && j.missed < maxMissed; t = cronExpr.Next(t) {
+			j.missed++
+			latest = t
+			if len(missed) < maxCatchUp {
+				missed = append(missed, t)
+			}
+		}
+		//错过的运行太多，不再逐个统计
+		if !t.IsZero() && !t.After(now) {
+			latest = lastRunBefore(cronExpr, latest, now)
+		}
+
+		//按策略补运行
+		switch policy {
+		case CatchUpOnce:
+			if len(missed) > 0 {
+				missed = []time.Time{latest}
+			}
+		case CatchUpSkip:
+			missed = nil
+		}
+		if j.missed > 0 {
+			log.Release("job %v missed %v runs since %v, catching up %v (%v)", name, j.missed, lastRun, len(missed), policy)
+		}
+
+		//补运行完成后再计划，避免计划的运行先于补运行
+		j.catchUp(missed, latest, now)
+	} else {
+		//第一次注册，以当前时间为起点，之后停机错过的运行可以被检测到
+		j.save(now)
+		//第一次计划
+		j.schedule(now)
+	}
+	s.jobs[name] = j
+
+	return j, nil
+}
+
+//获取任务
+func (s *Scheduler) Job(name string) *Job {
+	return s.jobs[name]
+}
+
+//获取所有任务的状态，按任务名排序
+func (s *Scheduler) Status() []Status {
+	status := make([]Status, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		status = append(status, j.Status())
+	}
+	sort.Slice(status, func(i, k int) bool {
+		return status[i].Name < status[k].Name
+	})
+
+	return status
+}
+
+//停止所有任务
+func (s *Scheduler) Stop() {
+	for _, j := range s.jobs {
+		j.Stop()
+	}
+}
+
+//补运行错过的运行，在模块的goroutine中逐个运行（每次补运行之间可以处理其他消息）
+//补运行完成后保存最后一次错过的运行latest，没有补运行（超过maxCatchUp次或不补运行）的运行视为已运行，下次启动时不再检测为错过
+//之后再计划from之后的下一次运行，补运行期间到期的运行在计划后立即运行
+func (j *Job) catchUp(missed []time.Time, latest time.Time, from time.Time) {
+	if len(missed) == 0 {
+		if latest.After(j.lastRun) {
+			j.lastRun = latest
+			j.save(latest)
+		}
+		j.schedule(from)
+		return
+	}
+
+	j.t = j.s.disp.AfterFunc(0, func() {
+		if j.stopped {
+			return
+		}
+
+		j.caughtUp++
+		j.run(missed[0])
+		j.catchUp(missed[1:], latest, from)
+	})
+}
+
+//查找(from, now]中最后一次运行的时间，没有时返回from
+//从now开始向前按倍增的时间窗口查找，避免从from开始逐个遍历
+func lastRunBefore(cronExpr *CronExpr, from time.Time, now time.Time) time.Time {
+	for d := time.Second; ; d *= 2 {
+		//窗口的起点不早于from
+		start := from
+		if d < now.Sub(from) {
+			start = now.Add(-d)
+		}
+
+		//窗口内有运行，向后遍历到最后一次
+		if t := cronExpr.Next(start); !t.IsZero() && !t.After(now) {
+			for next := cronExpr.Next(t); !next.IsZero() && !next.After(now); next = cronExpr.Next(next) {
+				t = next
+			}
+			return t
+		}
+
+		//已查找到from
+		if start.Equal(from) {
+			return from
+		}
+	}
+}
+
+//计划from之后的下一次运行
+func (j *Job) schedule(from time.Time) {
+	next := j.cronExpr.Next(from)
+	j.nextRun = next
+	//没有下一次运行
+	if next.IsZero() {
+		return
+	}
+
+	j.t = j.s.disp.AfterFunc(next.Sub(j.s.disp.Now()), func() {
+		//计划下一次运行之后再运行本次，从计划时间和当前时间中较晚的一个开始计划，避免重复运行
+		from := j.s.disp.Now()
+		if next.After(from) {
+			from = next
+		}
+		j.schedule(from)
+		j.run(next)
+	})
+}
+
+//运行任务并保存计划时间
+func (j *Job) run(scheduled time.Time) {
+	j.runs++
+
+	func() {
+		//捕获异常
+		defer func() {
+			if r := recover(); r != nil {
+				j.lastErr = fmt.Sprint(r)
+				if conf.LenStackBuf > 0 { //配置了堆栈buf长度大于0，打印堆栈信息
+					buf := make([]byte, conf.LenStackBuf)
+					l := runtime.Stack(buf, false)
+					log.Error("job %v: %v: %s", j.name, r, buf[:l])
+				} else { //打印异常
+					log.Error("job %v: %v", j.name, r)
+				}
+			}
+		}()
+
+		j.cb()
+	}()
+
+	//异常时也视为已运行，不再补运行
+	if scheduled.After(j.lastRun) {
+		j.lastRun = scheduled
+	}
+	j.save(j.lastRun)
+}
+
+//保存最后一次运行的计划时间
+func (j *Job) save(lastRun time.Time) {
+	//不持久化
+	if j.s.store == nil {
+		return
+	}
+
+	if err := j.s.store.Save(j.name, lastRun); err != nil {
+		j.lastErr = err.Error()
+		log.Error("job %v: save last run: %v", j.name, err)
+	}
+}
+
+//停止任务，未运行的补运行也不再运行，停止后可以重新注册同名任务
+func (j *Job) Stop() {
+	//从调度器中删除
+	if j.s.jobs[j.name] == j {
+		delete(j.s.jobs, j.name)
+	}
+
+	j.stopped = true
+	j.nextRun = time.Time{}
+	if j.t != nil {
+		j.t.Stop()
+	}
+}
+
+//获取任务状态
+func (j *Job) Status() Status {
+	return Status{
+		Name:     j.name,
+		Expr:     j.expr,
+		Policy:   j.policy,
+		LastRun:  j.lastRun,
+		NextRun:  j.nextRun,
+		Runs:     j.runs,
+		Missed:   j.missed,
+		CaughtUp: j.caughtUp,
+		LastErr:  j.lastErr,
+	}
+}
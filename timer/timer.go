@@ -5,63 +5,327 @@ import (
 	"runtime"
 	"squash/conf"
 	"squash/log"
+	"sync"
 	"time"
 )
 
+//可停止的底层定时器，*time.Timer实现了该接口
+type Stopper interface {
+	Stop() bool //停止定时器，定时器还未到期时返回true
+}
+
+//定时器后端，负责在时间段过去之后调用函数
+type Backend interface {
+	AfterFunc(d time.Duration, f func()) Stopper //等待时间段d过去之后，在其他goroutine中调用f
+}
+
 //定时器
+//除了到期投递，定时器的方法都应在读取ChanTimer的goroutine（模块的goroutine）中调用
 type Timer struct {
-	t  *time.Timer //底层定时器
-	cb func()      //回调
+	cb    func()      //回调，为空时定时器未启动
+	f     func()      //注册的回调，重置时恢复
+	disp  *Dispatcher //分发器
+	group *Group      //所属定时器组，为空时不属于任何组
+
+	mutex     sync.Mutex    //保护以下字段，底层定时器在其他goroutine中到期
+	t         Stopper       //底层定时器
+	gen       uint64        //启动代数，停止、重置和暂停时增加，用于忽略过期的到期
+	fired     int           //已投递到ChanTimer还未执行的次数，停止、重置和暂停时清零
+	deadline  time.Time     //到期时间
+	interval  time.Duration //周期定时器的间隔，为0时为一次性定时器
+	paused    bool          //是否已暂停
+	remaining time.Duration //暂停时的剩余时间
 }
 
 //计划任务
 type Cron struct {
-	t *Timer
+	t     *Timer //下一次调用回调的定时器
+	group *Group //所属定时器组，为空时不属于任何组
 }
 
 //分发器
 type Dispatcher struct {
-	ChanTimer chan *Timer //用于传输定时器的管道
+	ChanTimer chan *Timer         //用于传输定时器的管道
+	clock     Clock               //时钟
+	groups    map[*Group]struct{} //非空的定时器组
 }
 
-//创建分发器
+//创建分发器，每个定时器使用一个runtime定时器
 func NewDispatcher(l int) *Dispatcher {
+	return NewDispatcherWithClock(l, RealClock)
+}
+
+//创建使用时间轮的分发器，tick为时间轮精度，适用于大量定时器的场景
+func NewWheelDispatcher(l int, tick time.Duration) *Dispatcher {
+	return NewDispatcherWithBackend(l, NewWheel(tick))
+}
+
+//创建使用指定后端的分发器，后端没有实现Clock接口时使用系统时间
+func NewDispatcherWithBackend(l int, backend Backend) *Dispatcher {
+	clock, ok := backend.(Clock)
+	if !ok {
+		clock = backendClock{backend}
+	}
+
+	return NewDispatcherWithClock(l, clock)
+}
+
+//创建使用指定时钟的分发器（如测试中使用FakeClock）
+func NewDispatcherWithClock(l int, clock Clock) *Dispatcher {
 	//创建分发器
 	disp := new(Dispatcher)
 	//创建用于传输定时器的管道
 	disp.ChanTimer = make(chan *Timer, l)
+	//保存时钟
+	disp.clock = clock
+	disp.groups = make(map[*Group]struct{})
 	return disp
 }
 
+//获取分发器时钟的当前时间
+func (disp *Dispatcher) Now() time.Time {
+	return disp.clock.Now()
+}
+
+//关闭分发器，后端需要关闭时（如时间轮）关闭后端
+func (disp *Dispatcher) Close() {
+	var backend interface{} = disp.clock
+	if bc, ok := backend.(backendClock); ok {
+		backend = bc.Backend
+	}
+
+	if c, ok := backend.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
+
+//关闭所有非空的定时器组（组中还有未到期的定时器或计划任务）并输出泄漏日志
+func (disp *Dispatcher) CloseGroups() {
+	for group := range disp.groups {
+		group.leak()
+	}
+}
+
 //注册定时器
 func (disp *Dispatcher) AfterFunc(d time.Duration, cb func()) *Timer {
+	return disp.newTimer(d, 0, cb)
+}
+
+//注册周期定时器，每隔时间段d调用一次回调
+//到期时间按注册时间加整数倍间隔计算，不会因为投递和执行的延迟而累积偏差；
+//上一次到期还未执行时的到期会被丢弃，错过的到期不会补发
+func (disp *Dispatcher) TickFunc(d time.Duration, cb func()) *Timer {
+	//间隔必须大于0
+	if d <= 0 {
+		panic("non-positive interval for TickFunc")
+	}
+
+	return disp.newTimer(d, d, cb)
+}
+
+//创建并启动定时器
+func (disp *Dispatcher) newTimer(d time.Duration, interval time.Duration, cb func()) *Timer {
 	//创建定时器
 	t := new(Timer)
 	//设置回调
 	t.cb = cb
+	t.f = cb
+	t.disp = disp
+	t.interval = interval
+
 	//等待时间段d过去之后，将定时器发送到分发器管道中
-	t.t = time.AfterFunc(d, func() {
-		disp.ChanTimer <- t
-	})
+	t.mutex.Lock()
+	t.start(d)
+	t.mutex.Unlock()
 
 	//返回定时器
 	return t
 }
 
+//启动底层定时器（需要持有锁）
+func (t *Timer) start(d time.Duration) {
+	t.gen++
+	t.deadline = t.disp.clock.Now().Add(d)
+	t.arm(d, t.gen)
+}
+
+//注册底层定时器（需要持有锁）
+func (t *Timer) arm(d time.Duration, gen uint64) {
+	t.t = t.disp.clock.AfterFunc(d, func() {
+		t.expire(gen)
+	})
+}
+
+//停止底层定时器，丢弃已投递的到期（需要持有锁）
+func (t *Timer) halt() {
+	t.gen++
+	t.fired = 0
+	if t.t != nil {
+		t.t.Stop()
+	}
+}
+
+//底层定时器到期（在其他goroutine中调用）
+func (t *Timer) expire(gen uint64) {
+	t.mutex.Lock()
+
+	//已经停止、重置或暂停
+	if gen != t.gen {
+		t.mutex.Unlock()
+		return
+	}
+
+	//周期定时器，按整数倍间隔注册下一次到期，跳过已经错过的到期
+	if t.interval > 0 {
+		now := t.disp.clock.Now()
+		for !t.deadline.After(now) {
+			t.deadline = t.deadline.Add(t.interval)
+		}
+		t.arm(t.deadline.Sub(now), gen)
+
+		//上一次到期还未执行，丢弃本次到期
+		if t.fired > 0 {
+			t.mutex.Unlock()
+			return
+		}
+	}
+
+	t.fired++
+	t.mutex.Unlock()
+
+	//发送到分发器管道中
+	t.disp.ChanTimer <- t
+}
+
 //停止定时器
 func (t *Timer) Stop() {
 	//停止底层定时器
-	t.t.Stop()
+	t.mutex.Lock()
+	t.halt()
+	t.paused = false
+	t.mutex.Unlock()
+
 	//置空回调函数
 	t.cb = nil
+
+	//从所属定时器组中删除
+	if t.group != nil {
+		t.group.remove(t)
+	}
+}
+
+//重置定时器，在时间段d之后到期（已停止或已到期的定时器会重新启动）
+//周期定时器的间隔同时改为d
+func (t *Timer) Reset(d time.Duration) {
+	t.mutex.Lock()
+	t.halt()
+	t.paused = false
+	if t.interval > 0 {
+		//间隔必须大于0
+		if d <= 0 {
+			t.mutex.Unlock()
+			panic("non-positive interval for Reset")
+		}
+		t.interval = d
+	}
+	t.start(d)
+	t.mutex.Unlock()
+
+	//恢复回调函数
+	t.cb = t.f
+
+	//重新加入所属定时器组
+	if t.group != nil {
+		t.group.add(t)
+	}
+}
+
+//暂停定时器，保留剩余时间
+func (t *Timer) Pause() {
+	//未启动
+	if t.cb == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	//已暂停
+	if t.paused {
+		return
+	}
+
+	//保存剩余时间（已到期未执行时为0，恢复后立即到期）
+	t.remaining = t.deadline.Sub(t.disp.clock.Now())
+	if t.remaining < 0 || t.fired > 0 {
+		t.remaining = 0
+	}
+
+	t.halt()
+	t.paused = true
+}
+
+//恢复暂停的定时器，在剩余时间之后到期
+func (t *Timer) Resume() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	//未暂停
+	if !t.paused {
+		return
+	}
+
+	t.paused = false
+	t.start(t.remaining)
+}
+
+//获取距离下一次到期的剩余时间，暂停时为暂停时的剩余时间，未启动时为0
+func (t *Timer) Remaining() time.Duration {
+	//未启动
+	if t.cb == nil {
+		return 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.paused {
+		return t.remaining
+	}
+
+	d := t.deadline.Sub(t.disp.clock.Now())
+	if d < 0 {
+		return 0
+	}
+
+	return d
 }
 
 //调用定时器的回调函数
 func (t *Timer) Cb() {
+	t.mutex.Lock()
+	//过期的投递（投递后定时器被停止、重置或暂停）
+	if t.fired == 0 {
+		t.mutex.Unlock()
+		return
+	}
+	t.fired--
+	ticker := t.interval > 0
+	t.mutex.Unlock()
+
+	//一次性定时器，执行前置空回调（回调中可以重置定时器）
+	cb := t.cb
+	if !ticker {
+		t.cb = nil
+
+		//已到期，从所属定时器组中删除
+		if t.group != nil {
+			t.group.remove(t)
+		}
+	}
+
 	//延迟执行
 	defer func() {
-		//置空回调
-		t.cb = nil
 		//捕获异常
 		if r := recover(); r != nil {
 			if conf.LenStackBuf > 0 { //配置了堆栈buf长度大于0，打印堆栈信息
@@ -75,8 +339,8 @@ func (t *Timer) Cb() {
 	}()
 
 	//回调不为空，调用回调
-	if t.cb != nil {
-		t.cb()
+	if cb != nil {
+		cb()
 	}
 }
 
@@ -90,7 +354,7 @@ func (disp *Dispatcher) CronFunc(expr string, _cb func()) (*Cron, error) {
 	}
 
 	//获取当前时间
-	now := time.Now()
+	now := disp.clock.Now()
 	//获取下一个时间
 	nextTime := cronExpr.Next(now)
 	//下一个时间为零值，返回错误，不注册后续的计划任务
@@ -107,11 +371,15 @@ func (disp *Dispatcher) CronFunc(expr string, _cb func()) (*Cron, error) {
 		defer _cb()
 
 		//获取当前时间
-		now := time.Now()
+		now := disp.clock.Now()
 		//获取下一个时间
 		nextTime := cronExpr.Next(now)
 		//下一个时间为零值，不注册后续的计划任务
 		if nextTime.IsZero() {
+			//已结束，从所属定时器组中删除
+			if cron.group != nil {
+				cron.group.removeCron(cron)
+			}
 			return
 		}
 
@@ -128,4 +396,9 @@ func (disp *Dispatcher) CronFunc(expr string, _cb func()) (*Cron, error) {
 //停止计划任务
 func (c *Cron) Stop() {
 	c.t.Stop()
+
+	//从所属定时器组中删除
+	if c.group != nil {
+		c.group.removeCron(c)
+	}
 }
@@ -0,0 +1,241 @@
+package timer
+
+import (
+	"sync"
+	"time"
+)
+
+//时间轮参数（与linux内核定时器相同）
+//第一级256个槽，每槽一个tick；其余四级各64个槽，每槽为上一级一圈的时间
+const (
+	wheelRootBits = 8
+	wheelNodeBits = 6
+	wheelRootSize = 1 << wheelRootBits
+	wheelNodeSize = 1 << wheelNodeBits
+	wheelRootMask = wheelRootSize - 1
+	wheelNodeMask = wheelNodeSize - 1
+	wheelMaxTicks = 1<<(wheelRootBits+4*wheelNodeBits) - 1 //最大的槽距离，更远的定时器放在最后一级，级联时重新计算
+)
+
+//时间轮上的定时器
+type wheelTimer struct {
+	w          *Wheel      //所属时间轮
+	expires    uint64      //到期的tick
+	f          func()      //到期时调用的函数
+	prev, next *wheelTimer //槽内链表
+}
+
+//停止定时器，定时器还未到期时返回true
+func (t *wheelTimer) Stop() bool {
+	t.w.mutex.Lock()
+	defer t.w.mutex.Unlock()
+
+	//不在槽内（已到期或已停止）
+	if t.next == nil {
+		return false
+	}
+
+	t.unlink()
+	return true
+}
+
+//从槽内链表中删除
+func (t *wheelTimer) unlink() {
+	t.prev.next = t.next
+	t.next.prev = t.prev
+	t.prev = nil
+	t.next = nil
+}
+
+//槽，带哨兵的双向循环链表
+type wheelSlot struct {
+	head wheelTimer
+}
+
+//初始化槽
+func (s *wheelSlot) init() {
+	s.head.prev = &s.head
+	s.head.next = &s.head
+}
+
+//添加定时器到槽尾
+func (s *wheelSlot) push(t *wheelTimer) {
+	t.prev = s.head.prev
+	t.next = &s.head
+	s.head.prev.next = t
+	s.head.prev = t
+}
+
+//取出槽内所有定时器追加到ts中，清空槽
+func (s *wheelSlot) take(ts []*wheelTimer) []*wheelTimer {
+	for t := s.head.next; t != &s.head; {
+		next := t.next
+		//断开链接，定时器不再属于任何槽
+		t.prev = nil
+		t.next = nil
+		ts = append(ts, t)
+		t = next
+	}
+	s.init()
+
+	return ts
+}
+
+//分层时间轮，实现Backend接口
+//所有定时器由一个goroutine按tick推进，相比每个定时器一个runtime定时器，大量定时器时开销更小
+//到期精度为一个tick，定时器不会提前到期
+type Wheel struct {
+	mutex     sync.Mutex                  //互斥锁
+	tick      time.Duration               //tick时长
+	start     time.Time                   //开始时间
+	jiffies   uint64                      //已处理的tick数
+	root      [wheelRootSize]wheelSlot    //第一级
+	nodes     [4][wheelNodeSize]wheelSlot //第二至五级
+	buf       []*wheelTimer               //级联使用的缓冲
+	closeChan chan struct{}               //关闭信号
+	closeOnce sync.Once                   //保证只关闭一次
+}
+
+//创建时间轮并开始推进，tick为精度
+func NewWheel(tick time.Duration) *Wheel {
+	w := newWheel(tick)
+	go w.run()
+
+	return w
+}
+
+//创建时间轮（不推进）
+func newWheel(tick time.Duration) *Wheel {
+	//tick不合法，使用默认值
+	if tick <= 0 {
+		tick = time.Millisecond
+	}
+
+	w := new(Wheel)
+	w.tick = tick
+	w.start = time.Now()
+	for i := range w.root {
+		w.root[i].init()
+	}
+	for i := range w.nodes {
+		for j := range w.nodes[i] {
+			w.nodes[i][j].init()
+		}
+	}
+	w.closeChan = make(chan struct{})
+
+	return w
+}
+
+//实现Backend接口的AfterFunc方法，到期时在时间轮的goroutine中调用f
+func (w *Wheel) AfterFunc(d time.Duration, f func()) Stopper {
+	t := &wheelTimer{w: w, f: f}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	//第e个tick在(e+1)*tick时处理，取满足(e+1)*tick >= now+d的最小e
+	due := time.Since(w.start) + d
+	e := uint64(0)
+	if due > 0 {
+		e = uint64((due+w.tick-1)/w.tick) - 1
+	}
+	//已经过去的tick，在下一次推进时处理
+	if e < w.jiffies {
+		e = w.jiffies
+	}
+
+	t.expires = e
+	w.add(t)
+
+	return t
+}
+
+//停止推进时间轮，未到期的定时器不再触发
+func (w *Wheel) Close() {
+	w.closeOnce.Do(func() {
+		close(w.closeChan)
+	})
+}
+
+//按到期时间将定时器放入对应的槽
+func (w *Wheel) add(t *wheelTimer) {
+	idx := t.expires - w.jiffies
+
+	switch {
+	case idx < wheelRootSize:
+		w.root[t.expires&wheelRootMask].push(t)
+	case idx < 1<<(wheelRootBits+wheelNodeBits):
+		w.nodes[0][(t.expires>>wheelRootBits)&wheelNodeMask].push(t)
+	case idx < 1<<(wheelRootBits+2*wheelNodeBits):
+		w.nodes[1][(t.expires>>(wheelRootBits+wheelNodeBits))&wheelNodeMask].push(t)
+	case idx < 1<<(wheelRootBits+3*wheelNodeBits):
+		w.nodes[2][(t.expires>>(wheelRootBits+2*wheelNodeBits))&wheelNodeMask].push(t)
+	default:
+		//超过最大距离，按最大距离放入最后一级，级联时重新计算
+		if idx > wheelMaxTicks {
+			idx = wheelMaxTicks
+		}
+		w.nodes[3][((w.jiffies+idx)>>(wheelRootBits+3*wheelNodeBits))&wheelNodeMask].push(t)
+	}
+}
+
+//将第level级的第index个槽中的定时器重新放入较低的级别，返回index
+func (w *Wheel) cascade(level int, index uint64) uint64 {
+	w.buf = w.nodes[level][index].take(w.buf[:0])
+	for i, t := range w.buf {
+		w.add(t)
+		w.buf[i] = nil
+	}
+
+	return index
+}
+
+//推进一个tick，返回到期的定时器
+func (w *Wheel) step() []*wheelTimer {
+	index := w.jiffies & wheelRootMask
+
+	//第一级转完一圈，从上一级级联
+	if index == 0 {
+		for level := 0; level < 4; level++ {
+			shift := wheelRootBits + uint(level)*wheelNodeBits
+			if w.cascade(level, (w.jiffies>>shift)&wheelNodeMask) != 0 {
+				break
+			}
+		}
+	}
+
+	w.jiffies++
+	return w.root[index].take(nil)
+}
+
+//推进时间轮
+func (w *Wheel) run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.closeChan:
+			return
+		case now := <-ticker.C:
+			//按实际经过的时间推进，ticker丢失的tick也会处理
+			target := uint64(now.Sub(w.start) / w.tick)
+
+			for {
+				w.mutex.Lock()
+				if w.jiffies >= target {
+					w.mutex.Unlock()
+					break
+				}
+				expired := w.step()
+				w.mutex.Unlock()
+
+				//在锁外调用，f阻塞（如分发器管道已满）时不影响其他goroutine添加和停止定时器
+				for _, t := range expired {
+					t.f()
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package timer
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+//手动推进时间轮直到jiffies，检查每个定时器在到期的tick调用
+func advance(t *testing.T, w *Wheel, jiffies uint64) {
+	for w.jiffies < jiffies {
+		now := w.jiffies
+		for _, wt := range w.step() {
+			if wt.expires != now {
+				t.Fatalf("timer expires at %v fired at %v", wt.expires, now)
+			}
+			wt.f()
+		}
+	}
+}
+
+//添加一个在第expires个tick到期的定时器
+func addAt(w *Wheel, expires uint64, f func()) *wheelTimer {
+	wt := &wheelTimer{w: w, expires: expires, f: f}
+	w.add(wt)
+
+	return wt
+}
+
+func TestWheelOrder(t *testing.T) {
+	w := newWheel(time.Millisecond)
+
+	var fired []uint64
+	expires := []uint64{5, 0, 300, 255, 256, 1, 16383, 16384, 16385, 1 << 20, 1<<20 + 7, 5}
+	for _, e := range expires {
+		e := e
+		addAt(w, e, func() { fired = append(fired, e) })
+	}
+
+	advance(t, w, 1<<20+8)
+	if len(fired) != len(expires) {
+		t.Fatalf("fired %v timers, want %v", len(fired), len(expires))
+	}
+	for i := 1; i < len(fired); i++ {
+		if fired[i] < fired[i-1] {
+			t.Fatalf("fired out of order: %v", fired)
+		}
+	}
+}
+
+func TestWheelCascade(t *testing.T) {
+	w := newWheel(time.Millisecond)
+	rnd := rand.New(rand.NewSource(1))
+
+	//定时器分布在所有级别，从不同的起点添加
+	n := 0
+	count := 0
+	for _, start := range []uint64{0, 100, 256, 1000, 16384, 70000} {
+		advance(t, w, start)
+		for _, max := range []uint64{1 << 8, 1 << 14, 1 << 20, 1 << 21} {
+			for i := 0; i < 50; i++ {
+				addAt(w, w.jiffies+uint64(rnd.Int63n(int64(max))), func() { count++ })
+				n++
+			}
+		}
+	}
+
+	advance(t, w, 70000+1<<21)
+	if count != n {
+		t.Fatalf("fired %v timers, want %v", count, n)
+	}
+}
+
+func TestWheelStop(t *testing.T) {
+	w := newWheel(time.Millisecond)
+
+	fired := false
+	wt := addAt(w, 20000, func() { fired = true })
+	advance(t, w, 16384+1)
+	//已级联到较低的级别
+	if !wt.Stop() {
+		t.Fatal("Stop returned false for a pending timer")
+	}
+	advance(t, w, 20001)
+	if fired {
+		t.Fatal("stopped timer fired")
+	}
+	if wt.Stop() {
+		t.Fatal("Stop returned true for a stopped timer")
+	}
+}
+
+func TestWheelAfterFunc(t *testing.T) {
+	w := NewWheel(time.Millisecond)
+	defer w.Close()
+
+	start := time.Now()
+	done := make(chan time.Duration, 1)
+	w.AfterFunc(20*time.Millisecond, func() { done <- time.Since(start) })
+
+	select {
+	case d := <-done:
+		//不会提前到期
+		if d < 20*time.Millisecond {
+			t.Fatalf("fired after %v, want >= 20ms", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timer did not fire")
+	}
+}
+
+//添加并停止定时器（大部分游戏定时器在到期前被停止或重置）
+func benchmarkAfterFuncStop(b *testing.B, backend Backend) {
+	f := func() {}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			backend.AfterFunc(time.Minute, f).Stop()
+		}
+	})
+}
+
+func BenchmarkWheelAfterFuncStop(b *testing.B) {
+	w := NewWheel(time.Millisecond)
+	defer w.Close()
+
+	benchmarkAfterFuncStop(b, w)
+}
+
+func BenchmarkRealClockAfterFuncStop(b *testing.B) {
+	benchmarkAfterFuncStop(b, RealClock)
+}
+
+//大量未到期的定时器存在时添加并停止定时器
+func benchmarkAfterFuncStopLoaded(b *testing.B, backend Backend) {
+	f := func() {}
+	pending := make([]Stopper, 100000)
+	for i := range pending {
+		pending[i] = backend.AfterFunc(time.Duration(i%3600+60)*time.Second, f)
+	}
+	defer func() {
+		for _, s := range pending {
+			s.Stop()
+		}
+	}()
+
+	benchmarkAfterFuncStop(b, backend)
+}
+
+func BenchmarkWheelAfterFuncStopLoaded(b *testing.B) {
+	w := NewWheel(time.Millisecond)
+	defer w.Close()
+
+	benchmarkAfterFuncStopLoaded(b, w)
+}
+
+func BenchmarkRealClockAfterFuncStopLoaded(b *testing.B) {
+	benchmarkAfterFuncStopLoaded(b, RealClock)
+}